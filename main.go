@@ -36,6 +36,24 @@ var (
 		Help: "Total number of successful HTTP requests to Odoo",
 	})
 
+	odooRetried = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_http_requests_odoo_retried_total",
+		Help: "Total number of retried HTTP requests to Odoo",
+	})
+	odooGaveUp = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_http_requests_odoo_gave_up_total",
+		Help: "Total number of HTTP requests to Odoo which failed after all retries",
+	})
+	odooCircuitOpen = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_http_requests_odoo_circuit_open_total",
+		Help: "Total number of HTTP requests to Odoo which were not sent because the circuit breaker was open",
+	})
+
+	odooDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_odoo_dead_lettered_records_total",
+		Help: "Total number of billing records rejected by Odoo which were moved to the dead letter directory of the outbox",
+	})
+
 	providerFailed = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "billing_cloud_collector_http_requests_provider_failed_total",
 		Help: "Total number of failed HTTP requests to the cloud provider",
@@ -46,19 +64,70 @@ var (
 		Help: "Total number of successful HTTP requests to the cloud provider",
 	})
 
+	setupFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_setup_failed_total",
+		Help: "Total number of collector runs which failed before querying the cloud provider, e.g. because of invalid configuration",
+	})
+
+	periodsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_periods_skipped_total",
+		Help: "Total number of missed billing periods which were not caught up because they were older than the maximum lookback",
+	})
+
+	providerZoneFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_http_requests_provider_zone_failed_total",
+		Help: "Total number of failed HTTP requests to a zone of the cloud provider",
+	}, []string{"zone"})
+
+	providerZoneSucceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_http_requests_provider_zone_succeeded_total",
+		Help: "Total number of successful HTTP requests to a zone of the cloud provider",
+	}, []string{"zone"})
+
+	providerZoneMissing = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_provider_zone_missing_total",
+		Help: "Total number of cloud provider resources which were not found in the zones which answered while other zones failed",
+	}, []string{"kind"})
+
+	orphanedResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "billing_cloud_collector_orphaned_resources",
+		Help: "Number of cloud provider resources without matching object in the cluster, which are not billed",
+	}, []string{"provider", "kind"})
+
+	unattributedUsage = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "billing_cloud_collector_unattributed_usage_total",
+		Help: "Total number of decisions of the unattributed usage policy for resources which cannot be attributed to an organization",
+	}, []string{"provider", "kind", "action"})
+
 	providerMetrics = map[string]prometheus.Counter{
 		"providerFailed":    providerFailed,
 		"providerSucceeded": providerSucceeded,
 	}
 
 	odooMetrics = map[string]prometheus.Counter{
-		"odooFailed":    odooFailed,
-		"odooSucceeded": odooSucceeded,
+		"odooFailed":       odooFailed,
+		"odooSucceeded":    odooSucceeded,
+		"odooRetried":      odooRetried,
+		"odooGaveUp":       odooGaveUp,
+		"odooCircuitOpen":  odooCircuitOpen,
+		"odooDeadLettered": odooDeadLettered,
+	}
+
+	collectorMetrics = map[string]prometheus.Counter{
+		"setupFailed":    setupFailed,
+		"periodsSkipped": periodsSkipped,
+	}
+
+	providerZoneMetrics = map[string]*prometheus.CounterVec{
+		"providerZoneFailed":    providerZoneFailed,
+		"providerZoneSucceeded": providerZoneSucceeded,
+		"providerZoneMissing":   providerZoneMissing,
 	}
 
 	allMetrics = map[string]map[string]prometheus.Counter{
-		"odooMetrics":     odooMetrics,
-		"providerMetrics": providerMetrics,
+		"odooMetrics":      odooMetrics,
+		"providerMetrics":  providerMetrics,
+		"collectorMetrics": collectorMetrics,
 	}
 )
 
@@ -130,19 +199,11 @@ func newApp() (context.Context, context.CancelFunc, *cli.App) {
 			},
 			&cli.IntFlag{
 				Name:  "collectInterval",
-				Usage: "Interval in which the exporter checks the cloud resources",
-				Value: 10,
+				Usage: "Deprecated and without effect, use --schedule of the collector instead",
 			},
 			&cli.IntFlag{
 				Name:  "billingHour",
-				Usage: "After which hour every day the objectstorage collector should start",
-				Value: 6,
-				Action: func(c *cli.Context, i int) error {
-					if i > 23 || i < 0 {
-						return fmt.Errorf("invalid billingHour value, needs to be between 0 and 23")
-					}
-					return nil
-				},
+				Usage: "Deprecated and without effect, use --schedule of the collector instead",
 			},
 			&cli.StringFlag{
 				Name:  "organizationOverride",
@@ -170,6 +231,11 @@ func newApp() (context.Context, context.CancelFunc, *cli.App) {
 				"uid", os.Getuid(),
 				"gid", os.Getgid(),
 			).Info("Starting up " + appName)
+			for _, name := range []string{"collectInterval", "billingHour"} {
+				if c.IsSet(name) {
+					log.Logger(c.Context).Info("Ignoring deprecated flag, use --schedule of the collector instead", "flag", name)
+				}
+			}
 			return nil
 		},
 		Action: func(c *cli.Context) error {
@@ -180,9 +246,9 @@ func newApp() (context.Context, context.CancelFunc, *cli.App) {
 			return nil
 		},
 		Commands: []*cli.Command{
-			cmd.ExoscaleCmds(allMetrics),
-			cmd.CloudscaleCmds(allMetrics),
-			cmd.SpksCMD(allMetrics, ctx),
+			cmd.ExoscaleCmds(allMetrics, providerZoneMetrics, orphanedResources, unattributedUsage),
+			cmd.CloudscaleCmds(allMetrics, orphanedResources, unattributedUsage),
+			cmd.SpksCMD(allMetrics),
 		},
 		ExitErrHandler: func(c *cli.Context, err error) {
 			if err != nil {
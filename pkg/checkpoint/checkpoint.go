@@ -0,0 +1,93 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+// State is the progress of a collector
+type State struct {
+	// Watermark is the start of the last billing window which was sent, skipped or is to be retried
+	Watermark time.Time `json:"watermark"`
+	// Retry holds the windows up to the watermark which failed, they are collected again by the next run
+	Retry []time.Time `json:"retry,omitempty"`
+}
+
+// Store persists the State of each collector.
+// Every collector gets its own file in the directory, so collectors running in separate pods can share a volume.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a Store in the given directory
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create checkpoint directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Load returns the state of the collector, ok is false if it has never sent anything
+func (s *Store) Load(name string) (state State, ok bool, err error) {
+	raw, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, false, nil
+	}
+	if err != nil {
+		return State{}, false, fmt.Errorf("cannot read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return State{}, false, fmt.Errorf("cannot decode checkpoint: %w", err)
+	}
+	return state, true, nil
+}
+
+// Save replaces the state of the collector
+func (s *Store) Save(name string, state State) error {
+	state.Watermark = state.Watermark.UTC()
+	for i := range state.Retry {
+		state.Retry[i] = state.Retry[i].UTC()
+	}
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("cannot encode checkpoint: %w", err)
+	}
+	tmp := s.path(name) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("cannot write checkpoint: %w", err)
+	}
+	return os.Rename(tmp, s.path(name))
+}
+
+// Pending returns the start of every window after the watermark up to and including target.
+// Windows starting before target minus maxLookback are not caught up anymore, their number is returned as skipped.
+// Without a watermark only target is returned.
+func Pending(watermark time.Time, ok bool, target time.Time, g scheduler.Granularity, maxLookback time.Duration) (periods []time.Time, skipped int) {
+	target = g.Truncate(target)
+	if !ok {
+		return []time.Time{target}, 0
+	}
+
+	from := g.Next(g.Truncate(watermark.In(target.Location())))
+	if oldest := g.Truncate(target.Add(-maxLookback)); from.Before(oldest) {
+		for p := from; p.Before(oldest); p = g.Next(p) {
+			skipped++
+		}
+		from = oldest
+	}
+
+	for p := from; !p.After(target); p = g.Next(p) {
+		periods = append(periods, p)
+	}
+	return periods, skipped
+}
@@ -0,0 +1,97 @@
+package checkpoint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+func TestPending(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 3, day, hour, 0, 0, 0, zurich)
+	}
+
+	tests := map[string]struct {
+		watermark       time.Time
+		ok              bool
+		target          time.Time
+		granularity     scheduler.Granularity
+		maxLookback     time.Duration
+		expected        []time.Time
+		expectedSkipped int
+	}{
+		"given no watermark, we should only get the target": {
+			target:      at(5, 10),
+			granularity: scheduler.Hourly,
+			maxLookback: 24 * time.Hour,
+			expected:    []time.Time{at(5, 10)},
+		},
+		"given the target has been sent already, we should get nothing": {
+			watermark:   at(5, 10),
+			ok:          true,
+			target:      at(5, 10),
+			granularity: scheduler.Hourly,
+			maxLookback: 24 * time.Hour,
+			expected:    nil,
+		},
+		"given three missed hours, we should get them and the target": {
+			watermark:   at(5, 6),
+			ok:          true,
+			target:      at(5, 10),
+			granularity: scheduler.Hourly,
+			maxLookback: 24 * time.Hour,
+			expected:    []time.Time{at(5, 7), at(5, 8), at(5, 9), at(5, 10)},
+		},
+		"given a watermark older than the lookback, we should start at the lookback": {
+			watermark:       at(1, 0),
+			ok:              true,
+			target:          at(5, 0),
+			granularity:     scheduler.Daily,
+			maxLookback:     48 * time.Hour,
+			expected:        []time.Time{at(3, 0), at(4, 0), at(5, 0)},
+			expectedSkipped: 1,
+		},
+		"given no lookback, we should only get the target and skip the missed periods": {
+			watermark:       at(5, 6),
+			ok:              true,
+			target:          at(5, 10),
+			granularity:     scheduler.Hourly,
+			maxLookback:     0,
+			expected:        []time.Time{at(5, 10)},
+			expectedSkipped: 3,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			periods, skipped := Pending(tc.watermark, tc.ok, tc.target, tc.granularity, tc.maxLookback)
+			assert.Equal(t, tc.expected, periods)
+			assert.Equal(t, tc.expectedSkipped, skipped)
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, ok, err := s.Load("dbaas")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	state := State{
+		Watermark: time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC),
+		Retry:     []time.Time{time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC)},
+	}
+	require.NoError(t, s.Save("dbaas", state))
+
+	loaded, ok, err := s.Load("dbaas")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, state, loaded)
+}
@@ -0,0 +1,201 @@
+package cloudscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/controlAPI"
+	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// clusterTag is the cloudscale tag with the id of the cluster a server, volume or floating IP belongs to
+	clusterTag = "appuio-cluster-id"
+	// namespaceTag is the cloudscale tag with the namespace a server, volume or floating IP is billed to
+	namespaceTag = "appuio-namespace"
+
+	productIdPrefixCompute    = "appcat-cloudscale-compute"
+	productIdPrefixVolume     = "appcat-cloudscale-volume"
+	productIdPrefixFloatingIP = "appcat-cloudscale-floatingip"
+)
+
+// ComputeResources are the cloudscale resources tagged with the cluster id
+type ComputeResources struct {
+	Servers     []cloudscale.Server
+	Volumes     []cloudscale.Volume
+	FloatingIPs []cloudscale.FloatingIP
+}
+
+// Compute gathers servers, volumes and floating IPs tagged with the cluster id from cloudscale
+type Compute struct {
+	client           *cloudscale.Client
+	k8sClient        k8s.Client
+	controlApiClient k8s.Client
+	salesOrder       string
+	clusterId        string
+	cloudZone        string
+	uomMapping       map[string]string
+	unattributed     unattributed.Policy
+}
+
+func NewCompute(client *cloudscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, unattributedPolicy unattributed.Policy) (*Compute, error) {
+	return &Compute{
+		client:           client,
+		k8sClient:        k8sClient,
+		controlApiClient: controlApiClient,
+		salesOrder:       salesOrder,
+		clusterId:        clusterId,
+		cloudZone:        cloudZone,
+		uomMapping:       uomMapping,
+		unattributed:     unattributedPolicy,
+	}, nil
+}
+
+// GetMetrics returns the billing records of the given hour.
+// cloudscale only reports the current resources, so the records of past hours are based on them as well.
+func (c *Compute) GetMetrics(ctx context.Context, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	logger := log.Logger(ctx)
+
+	tagFilter := cloudscale.WithTagFilter(cloudscale.TagMap{clusterTag: c.clusterId})
+
+	logger.V(1).Info("fetching compute resources from cloudscale")
+	servers, err := c.client.Servers.List(ctx, tagFilter)
+	if err != nil {
+		return nil, fmt.Errorf("server list: %w", err)
+	}
+	volumes, err := c.client.Volumes.List(ctx, tagFilter)
+	if err != nil {
+		return nil, fmt.Errorf("volume list: %w", err)
+	}
+	floatingIPs, err := c.client.FloatingIPs.List(ctx, tagFilter)
+	if err != nil {
+		return nil, fmt.Errorf("floating ip list: %w", err)
+	}
+
+	// Fetch organisations in case salesOrder is missing
+	var nsTenants map[string]string
+	if c.salesOrder == "" {
+		logger.V(1).Info("Sales order id is missing, fetching namespaces to get the associated org id")
+		nsTenants, err = kubernetes.FetchNamespaceWithOrganizationMap(ctx, c.k8sClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.AggregateCompute(ctx, ComputeResources{Servers: servers, Volumes: volumes, FloatingIPs: floatingIPs}, nsTenants, billingHour)
+}
+
+// AggregateCompute creates the billing records of the running servers, the volumes and the floating IPs for the given billing hour.
+// Servers and floating IPs are billed per hour, volumes per GB and day, prorated to the hour.
+func (c *Compute) AggregateCompute(ctx context.Context, resources ComputeResources, nsTenants map[string]string, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	// the billing hour is in the location of the schedule, which the hours are aligned to
+	from := scheduler.Hourly.Truncate(billingHour)
+	timeRange := odoo.TimeRange{
+		From: from.In(time.UTC),
+		To:   scheduler.Hourly.Next(from).In(time.UTC),
+	}
+
+	report := c.unattributed.NewReport()
+	defer report.Log(ctx)
+
+	allRecords := make([]odoo.OdooMeteredBillingRecord, 0)
+	add := func(kind string, tags cloudscale.TagMap, record odoo.OdooMeteredBillingRecord) {
+		if tags[clusterTag] != c.clusterId {
+			return
+		}
+		itemGroup, salesOrder, ok := c.attribute(ctx, report, kind, record.InstanceID, tags[namespaceTag], nsTenants)
+		if !ok {
+			return
+		}
+		record.ItemGroupDescription = itemGroup
+		record.SalesOrder = salesOrder
+		record.TimeRange = timeRange
+		allRecords = append(allRecords, record)
+	}
+
+	for _, server := range resources.Servers {
+		if server.Status != "running" {
+			continue
+		}
+		add("Server", server.Tags, odoo.OdooMeteredBillingRecord{
+			ProductID:       fmt.Sprintf("%s-%s", productIdPrefixCompute, server.Flavor.Slug),
+			InstanceID:      fmt.Sprintf("%s/%s", server.Zone.Slug, server.UUID),
+			ItemDescription: server.Name,
+			UnitID:          c.uomMapping[odoo.InstanceHour],
+			ConsumedUnits:   1,
+		})
+	}
+
+	for _, volume := range resources.Volumes {
+		add("Volume", volume.Tags, odoo.OdooMeteredBillingRecord{
+			ProductID:       fmt.Sprintf("%s-%s", productIdPrefixVolume, volume.Type),
+			InstanceID:      fmt.Sprintf("%s/%s", volume.Zone.Slug, volume.UUID),
+			ItemDescription: volume.Name,
+			UnitID:          c.uomMapping[odoo.GBDay],
+			ConsumedUnits:   float64(volume.SizeGB) / 24,
+		})
+	}
+
+	for _, ip := range resources.FloatingIPs {
+		// floating IPs are either bound to a region or global
+		region := "global"
+		if ip.Region != nil {
+			region = ip.Region.Slug
+		}
+		add("FloatingIP", ip.Tags, odoo.OdooMeteredBillingRecord{
+			ProductID:       fmt.Sprintf("%s-ipv%d", productIdPrefixFloatingIP, ip.IPVersion),
+			InstanceID:      fmt.Sprintf("%s/%s", region, ip.Network),
+			ItemDescription: ip.Network,
+			UnitID:          c.uomMapping[odoo.InstanceHour],
+			ConsumedUnits:   1,
+		})
+	}
+
+	return allRecords, nil
+}
+
+// attribute returns the item group and sales order of a resource in the given namespace.
+// On APPUiO Cloud, the unattributed usage policy decides how to bill resources whose namespace has no organization.
+// It returns false if the resource must not be billed.
+func (c *Compute) attribute(ctx context.Context, report *unattributed.Report, kind, name, namespace string, nsTenants map[string]string) (itemGroup, salesOrder string, ok bool) {
+	if c.salesOrder != "" {
+		return fmt.Sprintf("APPUiO Managed - Cluster: %s / Namespace: %s", c.clusterId, namespace), c.salesOrder, true
+	}
+
+	itemGroup = fmt.Sprintf("APPUiO Cloud - Zone: %s / Namespace: %s", c.cloudZone, namespace)
+	organization, ok := nsTenants[namespace]
+	if !ok {
+		reason := "namespace without organization"
+		if namespace == "" {
+			reason = "no namespace"
+		}
+		decision := report.Decide(kind, name, reason)
+		switch decision.Action {
+		case unattributed.Skip:
+			return "", "", false
+		case unattributed.BillSalesOrder:
+			return itemGroup, decision.SalesOrder, true
+		}
+		organization = decision.Organization
+	}
+	salesOrder, err := controlAPI.GetSalesOrder(ctx, c.controlApiClient, organization)
+	if err != nil {
+		log.Logger(ctx).Error(err, "unable to sync compute resource", "resource", name)
+		return "", "", false
+	}
+	return itemGroup, salesOrder, true
+}
+
+func CheckComputeUnitExistence(mapping map[string]string) error {
+	if mapping[odoo.InstanceHour] == "" || mapping[odoo.GBDay] == "" {
+		return fmt.Errorf("missing UOM mapping %s or %s", odoo.InstanceHour, odoo.GBDay)
+	}
+	return nil
+}
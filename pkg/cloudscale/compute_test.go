@@ -0,0 +1,89 @@
+package cloudscale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+)
+
+func TestCompute_AggregateCompute(t *testing.T) {
+	ctx := log.NewLoggingContext(context.Background(), logr.Discard())
+
+	location, _ := time.LoadLocation("Europe/Zurich")
+	hour := time.Date(2024, 3, 5, 10, 0, 0, 0, location)
+	timeRange := odoo.TimeRange{
+		From: hour.In(time.UTC),
+		To:   hour.Add(time.Hour).In(time.UTC),
+	}
+	tags := cloudscale.TagMap{clusterTag: "c-test1", namespaceTag: "vshn-xyz"}
+
+	c, err := NewCompute(nil, nil, nil, "1234", "c-test1", "", map[string]string{odoo.InstanceHour: "uom-instance-hour", odoo.GBDay: "uom-gb-day"}, unattributed.Policy{})
+	require.NoError(t, err)
+
+	records, err := c.AggregateCompute(ctx, ComputeResources{
+		Servers: []cloudscale.Server{
+			{ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "lpg1"}}, TaggedResource: cloudscale.TaggedResource{Tags: tags}, UUID: "a", Name: "node-a", Status: "running", Flavor: cloudscale.Flavor{Slug: "flex-8-4"}},
+			{ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "lpg1"}}, TaggedResource: cloudscale.TaggedResource{Tags: tags}, UUID: "b", Name: "node-b", Status: "stopped", Flavor: cloudscale.Flavor{Slug: "flex-8-4"}},
+		},
+		Volumes: []cloudscale.Volume{
+			{ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}}, TaggedResource: cloudscale.TaggedResource{Tags: tags}, UUID: "v", Name: "data", Type: "ssd", SizeGB: 48},
+			{ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "rma1"}}, TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{clusterTag: "c-other"}}, UUID: "w", Name: "other", Type: "ssd", SizeGB: 48},
+		},
+		FloatingIPs: []cloudscale.FloatingIP{
+			{TaggedResource: cloudscale.TaggedResource{Tags: tags}, Network: "192.0.2.1/32", IPVersion: 4},
+		},
+	}, nil, hour)
+	require.NoError(t, err)
+
+	group := "APPUiO Managed - Cluster: c-test1 / Namespace: vshn-xyz"
+	assert.Equal(t, []odoo.OdooMeteredBillingRecord{
+		{ProductID: "appcat-cloudscale-compute-flex-8-4", InstanceID: "lpg1/a", ItemDescription: "node-a", ItemGroupDescription: group, SalesOrder: "1234", UnitID: "uom-instance-hour", ConsumedUnits: 1, TimeRange: timeRange},
+		{ProductID: "appcat-cloudscale-volume-ssd", InstanceID: "rma1/v", ItemDescription: "data", ItemGroupDescription: group, SalesOrder: "1234", UnitID: "uom-gb-day", ConsumedUnits: 2, TimeRange: timeRange},
+		{ProductID: "appcat-cloudscale-floatingip-ipv4", InstanceID: "global/192.0.2.1/32", ItemDescription: "192.0.2.1/32", ItemGroupDescription: group, SalesOrder: "1234", UnitID: "uom-instance-hour", ConsumedUnits: 1, TimeRange: timeRange},
+	}, records)
+}
+
+func TestCompute_AggregateCompute_Unattributed(t *testing.T) {
+	ctx := log.NewLoggingContext(context.Background(), logr.Discard())
+	hour := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	servers := []cloudscale.Server{
+		{ZonalResource: cloudscale.ZonalResource{Zone: cloudscale.Zone{Slug: "lpg1"}}, TaggedResource: cloudscale.TaggedResource{Tags: cloudscale.TagMap{clusterTag: "c-test1"}}, UUID: "a", Name: "node-a", Status: "running", Flavor: cloudscale.Flavor{Slug: "flex-8-4"}},
+	}
+
+	tests := map[string]struct {
+		policy         unattributed.Policy
+		wantSalesOrder []string
+	}{
+		"given policy skip, we should not bill the server": {
+			policy:         unattributed.Policy{Action: unattributed.Skip},
+			wantSalesOrder: []string{},
+		},
+		"given policy sales-order, we should bill the server to the internal sales order": {
+			policy:         unattributed.Policy{Action: unattributed.BillSalesOrder, SalesOrder: "S-internal"},
+			wantSalesOrder: []string{"S-internal"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewCompute(nil, nil, nil, "", "c-test1", "cloudscale-zone", map[string]string{odoo.InstanceHour: "uom-instance-hour", odoo.GBDay: "uom-gb-day"}, tc.policy)
+			require.NoError(t, err)
+
+			records, err := c.AggregateCompute(ctx, ComputeResources{Servers: servers}, map[string]string{}, hour)
+			require.NoError(t, err)
+
+			salesOrders := make([]string, 0, len(records))
+			for _, r := range records {
+				salesOrders = append(salesOrders, r.SalesOrder)
+			}
+			assert.Equal(t, tc.wantSalesOrder, salesOrders)
+		})
+	}
+}
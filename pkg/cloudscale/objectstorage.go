@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
 	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
@@ -33,6 +35,7 @@ type ObjectStorage struct {
 	cloudZone        string
 	uomMapping       map[string]string
 	providerMetrics  map[string]prometheus.Counter
+	unattributed     unattributed.Policy
 }
 
 const (
@@ -45,7 +48,7 @@ type ObjectStorageData struct {
 	Organization string
 }
 
-func NewObjectStorage(client *cloudscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, providerMetrics map[string]prometheus.Counter) (*ObjectStorage, error) {
+func NewObjectStorage(client *cloudscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, providerMetrics map[string]prometheus.Counter, unattributedPolicy unattributed.Policy) (*ObjectStorage, error) {
 	return &ObjectStorage{
 		client:           client,
 		k8sClient:        k8sClient,
@@ -55,15 +58,23 @@ func NewObjectStorage(client *cloudscale.Client, k8sClient k8s.Client, controlAp
 		cloudZone:        cloudZone,
 		uomMapping:       uomMapping,
 		providerMetrics:  providerMetrics,
+		unattributed:     unattributedPolicy,
 	}, nil
 }
 
+// GetMetrics returns the billing records of the given day
 func (o *ObjectStorage) GetMetrics(ctx context.Context, billingDate time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	return o.GetMetricsRange(ctx, billingDate, billingDate)
+}
+
+// GetMetricsRange returns the billing records of every day from start to end, both inclusive.
+// The metrics of all days are fetched in a single request, a record set is created per day of the returned time series.
+func (o *ObjectStorage) GetMetricsRange(ctx context.Context, start, end time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	logger := log.Logger(ctx)
 
-	logger.V(1).Info("fetching bucket metrics from cloudscale", "date", billingDate)
+	logger.V(1).Info("fetching bucket metrics from cloudscale", "start", start, "end", end)
 
-	bucketMetricsRequest := cloudscale.BucketMetricsRequest{Start: billingDate, End: billingDate}
+	bucketMetricsRequest := cloudscale.BucketMetricsRequest{Start: start, End: end}
 	bucketMetrics, err := o.client.Metrics.GetBucketMetrics(ctx, &bucketMetricsRequest)
 	if err != nil {
 		o.providerMetrics["providerFailed"].Inc()
@@ -79,20 +90,22 @@ func (o *ObjectStorage) GetMetrics(ctx context.Context, billingDate time.Time) (
 		}
 	}
 
-	// Since our buckets are always created in the convention $namespace.$bucketname, we can extract the namespace from the bucket name by splitting it.
-	// However, we need to fetch the user details to get the actual namespace.
-	for key, bucket := range bucketMap {
-		// fetch bucket user by id
-		logger.Info("fetching user details", "userID", bucket.Subject.ObjectsUserID)
-		userDetails, err := o.client.ObjectsUsers.Get(ctx, bucket.Subject.ObjectsUserID)
-		if err != nil {
-			o.providerMetrics["providerFailed"].Inc()
-			logger.Error(err, "unknown userID, something broke here fatally", "userID", bucket.Subject.ObjectsUserID, "bucket", bucket)
-			// deleting this bucket as it's unsuable
-			delete(bucketMap, key)
-			continue
-		}
-		bucket.BucketDetail.Namespace = strings.Split(userDetails.DisplayName, ".")[0]
+	logger.V(1).Info("fetching buckets and objects users")
+	buckets, err := fetchBuckets(ctx, o.k8sClient)
+	if err != nil {
+		o.providerMetrics["providerFailed"].Inc()
+		return nil, err
+	}
+	userNamespaces, err := fetchObjectsUserNamespaces(ctx, o.k8sClient)
+	if err != nil {
+		o.providerMetrics["providerFailed"].Inc()
+		return nil, err
+	}
+
+	unresolved := attributeFromCluster(bucketMap, buckets, userNamespaces)
+	if err := o.attributeFromDisplayName(ctx, bucketMap, unresolved); err != nil {
+		// billing the buckets as unattributed would bill them again to the customer once the objects user can be fetched
+		return nil, fmt.Errorf("cannot attribute buckets: %w", err)
 	}
 
 	// Fetch organisations in case salesOrder is missing
@@ -106,14 +119,6 @@ func (o *ObjectStorage) GetMetrics(ctx context.Context, billingDate time.Time) (
 		}
 	}
 
-	logger.V(1).Info("fetching buckets")
-
-	buckets, err := fetchBuckets(ctx, o.k8sClient)
-	if err != nil {
-		o.providerMetrics["providerFailed"].Inc()
-		return nil, err
-	}
-
 	for name, bucket := range bucketMap {
 		if val, ok := buckets[name]; ok {
 			bucket.Zone = val.Zone
@@ -125,50 +130,65 @@ func (o *ObjectStorage) GetMetrics(ctx context.Context, billingDate time.Time) (
 		}
 	}
 
-	allRecords := make([]odoo.OdooMeteredBillingRecord, 0)
-	for _, bucket := range bucketMap {
+	report := o.unattributed.NewReport()
+	defer report.Log(ctx)
 
-		appuioManaged := true
+	allRecords := make([]odoo.OdooMeteredBillingRecord, 0)
+	for name, bucket := range bucketMap {
+		appuioManaged := o.salesOrder != ""
 		salesOrder := o.salesOrder
-		if salesOrder == "" {
-			appuioManaged = false
-			if bucket.Organization == "" {
-				// in cases that our VSHN services are using buckets, then Organization is not set, we must default it to "vshn"
-				// we can't set it in cluster as for customers as then we might run into scheduling issues
-				bucket.Organization = "vshn"
+
+		// buckets of our VSHN services are not in a customer namespace, the policy decides how to bill them
+		reason := ""
+		if bucket.Namespace == "" {
+			reason = "no namespace"
+		} else if !appuioManaged && bucket.Organization == "" {
+			reason = "namespace without organization"
+		}
+		if reason != "" {
+			decision := report.Decide("Bucket", name, reason)
+			switch decision.Action {
+			case unattributed.Skip:
+				continue
+			case unattributed.BillSalesOrder:
+				salesOrder = decision.SalesOrder
+			case unattributed.BillOrganization:
+				bucket.Organization = decision.Organization
 			}
+		}
+
+		if salesOrder == "" {
 			salesOrder, err = controlAPI.GetSalesOrder(ctx, o.controlApiClient, bucket.Organization)
 			if err != nil {
 				logger.Error(err, "unable to sync bucket", "namespace", bucket, "reason", err)
 				continue
 			}
 		}
-		records, err := o.createOdooRecord(bucket.BucketMetricsData, bucket.BucketDetail, appuioManaged, salesOrder, billingDate)
-		if err != nil {
-			logger.Error(err, "unable to create Odoo Record", "namespace", bucket.Namespace)
-			continue
+		for _, interval := range bucket.TimeSeries {
+			records, err := o.createOdooRecord(bucket.Subject, interval, bucket.BucketDetail, appuioManaged, salesOrder)
+			if err != nil {
+				logger.Error(err, "unable to create Odoo Record", "namespace", bucket.Namespace, "day", interval.Start)
+				continue
+			}
+			allRecords = append(allRecords, records...)
+			logger.V(1).Info("Created Odoo records", "namespace", bucket, "records", records)
 		}
-		allRecords = append(allRecords, records...)
-		logger.V(1).Info("Created Odoo records", "namespace", bucket, "records", records)
 	}
 	o.providerMetrics["providerSucceeded"].Inc()
 	return allRecords, nil
 }
 
-func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetricsData, b BucketDetail, appuioManaged bool, salesOrder string, billingDate time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
-	if len(bucketMetricsData.TimeSeries) != 1 {
-		return nil, fmt.Errorf("there must be exactly one metrics data point, found %d", len(bucketMetricsData.TimeSeries))
-	}
-
-	storageBytesValue, err := convertUnit(units[productIdStorage], uint64(bucketMetricsData.TimeSeries[0].Usage.StorageBytes))
+// createOdooRecord creates the records of a bucket for the day of a metrics interval
+func (o *ObjectStorage) createOdooRecord(subject cloudscale.BucketMetricsDataSubject, interval cloudscale.BucketMetricsInterval, b BucketDetail, appuioManaged bool, salesOrder string) ([]odoo.OdooMeteredBillingRecord, error) {
+	storageBytesValue, err := convertUnit(units[productIdStorage], uint64(interval.Usage.StorageBytes))
 	if err != nil {
 		return nil, err
 	}
-	trafficOutValue, err := convertUnit(units[productIdTrafficOut], uint64(bucketMetricsData.TimeSeries[0].Usage.SentBytes))
+	trafficOutValue, err := convertUnit(units[productIdTrafficOut], uint64(interval.Usage.SentBytes))
 	if err != nil {
 		return nil, err
 	}
-	queryRequestsValue, err := convertUnit(units[productIdQueryRequests], uint64(bucketMetricsData.TimeSeries[0].Usage.Requests))
+	queryRequestsValue, err := convertUnit(units[productIdQueryRequests], uint64(interval.Usage.Requests))
 	if err != nil {
 		return nil, err
 	}
@@ -180,8 +200,14 @@ func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetr
 		itemGroup = fmt.Sprintf("APPUiO Cloud - Zone: %s / Namespace: %s", o.cloudZone, b.Namespace)
 	}
 
-	instanceId := fmt.Sprintf("%s/%s", b.Zone, bucketMetricsData.Subject.BucketName)
+	instanceId := fmt.Sprintf("%s/%s", b.Zone, subject.BucketName)
 
+	// the intervals start at midnight in Europe/Zurich
+	location, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		return nil, fmt.Errorf("load location: %w", err)
+	}
+	billingDate := interval.Start.In(location)
 	billingStart := time.Date(billingDate.Year(), billingDate.Month(), billingDate.Day(), 0, 0, 0, 0, time.UTC)
 	billingEnd := time.Date(billingDate.Year(), billingDate.Month(), billingDate.Day()+1, 0, 0, 0, 0, time.UTC)
 
@@ -189,7 +215,7 @@ func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetr
 		{
 			ProductID:            productIdStorage,
 			InstanceID:           instanceId + "/storage",
-			ItemDescription:      bucketMetricsData.Subject.BucketName,
+			ItemDescription:      subject.BucketName,
 			ItemGroupDescription: itemGroup,
 			SalesOrder:           salesOrder,
 			UnitID:               o.uomMapping[units[productIdStorage]],
@@ -202,7 +228,7 @@ func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetr
 		{
 			ProductID:            productIdTrafficOut,
 			InstanceID:           instanceId + "/trafficout",
-			ItemDescription:      bucketMetricsData.Subject.BucketName,
+			ItemDescription:      subject.BucketName,
 			ItemGroupDescription: itemGroup,
 			SalesOrder:           salesOrder,
 			UnitID:               o.uomMapping[units[productIdTrafficOut]],
@@ -215,7 +241,7 @@ func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetr
 		{
 			ProductID:            productIdQueryRequests,
 			InstanceID:           instanceId + "/requests",
-			ItemDescription:      bucketMetricsData.Subject.BucketName,
+			ItemDescription:      subject.BucketName,
 			ItemGroupDescription: itemGroup,
 			SalesOrder:           salesOrder,
 			UnitID:               o.uomMapping[units[productIdQueryRequests]],
@@ -228,9 +254,60 @@ func (o *ObjectStorage) createOdooRecord(bucketMetricsData cloudscale.BucketMetr
 	}, nil
 }
 
+// attributeFromCluster sets the namespace of the buckets from the claim namespace label of their Bucket, or else of the ObjectsUser owning them.
+// It returns the names of the buckets which have neither.
+func attributeFromCluster(bucketMap map[string]*ObjectStorageData, buckets map[string]BucketDetail, userNamespaces map[string]string) []string {
+	unresolved := make([]string, 0)
+	for name, bucket := range bucketMap {
+		if b, ok := buckets[name]; ok && b.Namespace != "" {
+			bucket.Namespace = b.Namespace
+			continue
+		}
+		if namespace, ok := userNamespaces[bucket.Subject.ObjectsUserID]; ok {
+			bucket.Namespace = namespace
+			continue
+		}
+		unresolved = append(unresolved, name)
+	}
+	sort.Strings(unresolved)
+	return unresolved
+}
+
+// attributeFromDisplayName sets the namespace of the given buckets from the display name of their objects user,
+// which follows the convention $namespace.$name for users created by AppCat.
+// Buckets whose objects user does not exist or has no display name are left without namespace.
+// An error is returned if some objects users cannot be fetched.
+func (o *ObjectStorage) attributeFromDisplayName(ctx context.Context, bucketMap map[string]*ObjectStorageData, names []string) error {
+	logger := log.Logger(ctx)
+	if len(names) == 0 {
+		return nil
+	}
+
+	userIDs := make([]string, 0, len(names))
+	for _, name := range names {
+		userIDs = append(userIDs, bucketMap[name].Subject.ObjectsUserID)
+	}
+	users, err := o.fetchObjectsUsers(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		bucket := bucketMap[name]
+		user, ok := users[bucket.Subject.ObjectsUserID]
+		if !ok || user.DisplayName == "" {
+			continue
+		}
+		bucket.Namespace = strings.Split(user.DisplayName, ".")[0]
+		logger.Info("Bucket and objects user not found in cluster, attributing bucket by display name of the objects user",
+			"bucket", name, "userID", user.ID, "displayName", user.DisplayName, "namespace", bucket.Namespace)
+	}
+	return nil
+}
+
 func fetchBuckets(ctx context.Context, k8sclient client.Client) (map[string]BucketDetail, error) {
 	buckets := &cloudscalev1.BucketList{}
-	if err := k8sclient.List(ctx, buckets, client.HasLabels{namespaceLabel}); err != nil {
+	if err := k8sclient.List(ctx, buckets); err != nil {
 		return nil, fmt.Errorf("bucket list: %w", err)
 	}
 
@@ -245,6 +322,23 @@ func fetchBuckets(ctx context.Context, k8sclient client.Client) (map[string]Buck
 	return bucketDetails, nil
 }
 
+// fetchObjectsUserNamespaces returns the claim namespace of the ObjectsUsers in the cluster by their cloudscale id
+func fetchObjectsUserNamespaces(ctx context.Context, k8sclient client.Client) (map[string]string, error) {
+	users := &cloudscalev1.ObjectsUserList{}
+	if err := k8sclient.List(ctx, users, client.HasLabels{namespaceLabel}); err != nil {
+		return nil, fmt.Errorf("objects user list: %w", err)
+	}
+
+	namespaces := make(map[string]string, len(users.Items))
+	for _, u := range users.Items {
+		if u.Status.AtProvider.ID == "" {
+			continue
+		}
+		namespaces[u.Status.AtProvider.ID] = u.Labels[namespaceLabel]
+	}
+	return namespaces, nil
+}
+
 func CheckUnitExistence(mapping map[string]string) error {
 	if mapping[odoo.GB] == "" || mapping[odoo.GBDay] == "" || mapping[odoo.KReq] == "" {
 		return fmt.Errorf("missing UOM mapping %s, %s or %s", odoo.GB, odoo.GBDay, odoo.KReq)
@@ -0,0 +1,69 @@
+package cloudscale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+)
+
+func TestObjectStorage_createOdooRecord(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	o, err := NewObjectStorage(nil, nil, nil, "1234", "c-test1", "", map[string]string{odoo.GB: "gb", odoo.GBDay: "gb-day", odoo.KReq: "kreq"}, nil, unattributed.Policy{})
+	require.NoError(t, err)
+
+	subject := cloudscale.BucketMetricsDataSubject{BucketName: "bucket"}
+	intervals := []cloudscale.BucketMetricsInterval{
+		{Start: time.Date(2024, 3, 4, 0, 0, 0, 0, zurich), End: time.Date(2024, 3, 5, 0, 0, 0, 0, zurich), Usage: cloudscale.BucketMetricsIntervalUsage{StorageBytes: 2e9, SentBytes: 1e9, Requests: 3000}},
+		{Start: time.Date(2024, 3, 5, 0, 0, 0, 0, zurich), End: time.Date(2024, 3, 6, 0, 0, 0, 0, zurich), Usage: cloudscale.BucketMetricsIntervalUsage{StorageBytes: 4e9}},
+	}
+
+	for i, day := range []int{4, 5} {
+		records, err := o.createOdooRecord(subject, intervals[i], BucketDetail{Namespace: "ns", Zone: "lpg"}, true, "1234")
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		for _, r := range records {
+			assert.Equal(t, odoo.TimeRange{
+				From: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2024, 3, day+1, 0, 0, 0, 0, time.UTC),
+			}, r.TimeRange)
+		}
+		assert.Equal(t, "lpg/bucket/storage", records[0].InstanceID)
+	}
+
+	records, err := o.createOdooRecord(subject, intervals[0], BucketDetail{Namespace: "ns", Zone: "lpg"}, true, "1234")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2, 1, 3}, []float64{records[0].ConsumedUnits, records[1].ConsumedUnits, records[2].ConsumedUnits})
+}
+
+func TestObjectStorage_attributeFromCluster(t *testing.T) {
+	bucket := func(name, userID string) *ObjectStorageData {
+		return &ObjectStorageData{BucketMetricsData: cloudscale.BucketMetricsData{Subject: cloudscale.BucketMetricsDataSubject{BucketName: name, ObjectsUserID: userID}}}
+	}
+	bucketMap := map[string]*ObjectStorageData{
+		"by-bucket":      bucket("by-bucket", "u1"),
+		"by-user":        bucket("by-user", "u2"),
+		"unlabelled":     bucket("unlabelled", "u3"),
+		"not-in-cluster": bucket("not-in-cluster", "u4"),
+	}
+
+	unresolved := attributeFromCluster(bucketMap, map[string]BucketDetail{
+		"by-bucket":  {Namespace: "ns-bucket"},
+		"by-user":    {},
+		"unlabelled": {},
+	}, map[string]string{
+		"u1": "ns-user1",
+		"u2": "ns-user2",
+	})
+
+	assert.Equal(t, []string{"not-in-cluster", "unlabelled"}, unresolved)
+	assert.Equal(t, "ns-bucket", bucketMap["by-bucket"].Namespace, "the label of the Bucket should take precedence")
+	assert.Equal(t, "ns-user2", bucketMap["by-user"].Namespace)
+	assert.Empty(t, bucketMap["unlabelled"].Namespace)
+}
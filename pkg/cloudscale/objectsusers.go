@@ -0,0 +1,79 @@
+package cloudscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+// objectsUserWorkers is the maximum number of objects users fetched at the same time if they are missing in the list
+const objectsUserWorkers = 8
+
+// fetchObjectsUsers returns the objects users with the given ids by their id.
+// All objects users are listed in a single request, only the ones missing in the list, e.g. because they were created in the meantime,
+// are fetched one by one with bounded concurrency. Objects users which do not exist are missing in the returned map,
+// if any other objects user cannot be fetched an error is returned, as the owner of its buckets is unknown.
+func (o *ObjectStorage) fetchObjectsUsers(ctx context.Context, ids []string) (map[string]cloudscale.ObjectsUser, error) {
+	logger := log.Logger(ctx)
+
+	users := make(map[string]cloudscale.ObjectsUser, len(ids))
+	logger.V(1).Info("listing objects users")
+	list, err := o.client.ObjectsUsers.List(ctx)
+	if err != nil {
+		o.providerMetrics["providerFailed"].Inc()
+		logger.Error(err, "cannot list objects users, fetching them one by one")
+	}
+	for _, user := range list {
+		users[user.ID] = user
+	}
+
+	missing := make([]string, 0)
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if _, ok := users[id]; !ok && !seen[id] {
+			missing = append(missing, id)
+		}
+		seen[id] = true
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, objectsUserWorkers)
+	for _, id := range missing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			logger.Info("fetching user details", "userID", id)
+			user, err := o.client.ObjectsUsers.Get(ctx, id)
+			if err != nil {
+				errResp := &cloudscale.ErrorResponse{}
+				if errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound {
+					logger.Info("objects user does not exist", "userID", id)
+					return
+				}
+				o.providerMetrics["providerFailed"].Inc()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("objects user %s: %w", id, err))
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			users[id] = *user
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return users, errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+package cloudscale
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+)
+
+func TestObjectStorage_fetchObjectsUsers(t *testing.T) {
+	var lists, gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/objects-users":
+			lists.Add(1)
+			_ = json.NewEncoder(w).Encode([]cloudscale.ObjectsUser{{ID: "u1", DisplayName: "ns1.user"}, {ID: "u2", DisplayName: "ns2.user"}})
+		case "/v1/objects-users/u3":
+			gets.Add(1)
+			_ = json.NewEncoder(w).Encode(cloudscale.ObjectsUser{ID: "u3", DisplayName: "ns3.user"})
+		case "/v1/objects-users/u5":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			gets.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := cloudscale.NewClient(server.Client())
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	metrics := map[string]prometheus.Counter{
+		"providerFailed":    prometheus.NewCounter(prometheus.CounterOpts{Name: "failed"}),
+		"providerSucceeded": prometheus.NewCounter(prometheus.CounterOpts{Name: "succeeded"}),
+	}
+	o, err := NewObjectStorage(client, nil, nil, "", "c-test1", "", nil, metrics, unattributed.Policy{})
+	require.NoError(t, err)
+
+	users, err := o.fetchObjectsUsers(log.NewLoggingContext(context.Background(), logr.Discard()), []string{"u1", "u1", "u2", "u3", "u4", "u4"})
+
+	assert.NoError(t, err, "objects users which do not exist should not be an error")
+	assert.Equal(t, int32(1), lists.Load(), "all objects users should be listed once")
+	assert.Equal(t, int32(2), gets.Load(), "only the users missing in the list should be fetched, once each")
+	assert.Len(t, users, 3)
+	assert.Equal(t, "ns3.user", users["u3"].DisplayName)
+
+	_, err = o.fetchObjectsUsers(log.NewLoggingContext(context.Background(), logr.Discard()), []string{"u1", "u5"})
+	assert.Error(t, err, "objects users which cannot be fetched should be an error")
+}
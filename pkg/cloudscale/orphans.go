@@ -0,0 +1,47 @@
+package cloudscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/orphans"
+	cloudscalev1 "github.com/vshn/provider-cloudscale/apis/cloudscale/v1"
+)
+
+const bucketKind = "Bucket"
+
+// FindOrphans returns the cloudscale buckets with metrics on the given day which have no matching Bucket in the cluster
+func (o *ObjectStorage) FindOrphans(ctx context.Context, date time.Time) (kinds []string, orphaned []orphans.Resource, err error) {
+	logger := log.Logger(ctx)
+	kinds = []string{bucketKind}
+
+	buckets := &cloudscalev1.BucketList{}
+	if err := o.k8sClient.List(ctx, buckets); err != nil {
+		return kinds, nil, fmt.Errorf("bucket list: %w", err)
+	}
+	claimed := make(map[string]bool, len(buckets.Items))
+	for _, b := range buckets.Items {
+		claimed[b.GetBucketName()] = true
+	}
+
+	bucketMetrics, err := o.client.Metrics.GetBucketMetrics(ctx, &cloudscale.BucketMetricsRequest{Start: date, End: date})
+	if err != nil {
+		o.providerMetrics["providerFailed"].Inc()
+		return kinds, nil, fmt.Errorf("bucket metrics: %w", err)
+	}
+	o.providerMetrics["providerSucceeded"].Inc()
+
+	resources := make([]orphans.Resource, 0, len(bucketMetrics.Data))
+	for _, data := range bucketMetrics.Data {
+		resources = append(resources, orphans.Resource{
+			Kind: bucketKind,
+			Name: data.Subject.BucketName,
+		})
+	}
+	orphaned = orphans.Find(resources, claimed)
+	logger.Info("Checked cloudscale buckets for orphans", "buckets", len(resources), "orphans", len(orphaned))
+	return kinds, orphaned, nil
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+// backfillOptions holds the flags of the backfill subcommands
+type backfillOptions struct {
+	from string
+	to   string
+}
+
+func (o *backfillOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "from", Usage: "First day (2006-01-02) or hour (2006-01-02T15:04) to bill, in the timezone of the collector",
+			Destination: &o.from, Required: true, DefaultText: defaultTextForRequiredFlags},
+		&cli.StringFlag{Name: "to", Usage: "Last day (2006-01-02) or hour (2006-01-02T15:04) to bill, inclusive, in the timezone of the collector",
+			Destination: &o.to, Required: true, DefaultText: defaultTextForRequiredFlags},
+	}
+}
+
+// periods returns the start of every day or hour between from and to, both inclusive.
+// The windows are aligned to the given location, which needs to be the one of the schedule so backfilled records match the scheduled ones.
+func (o backfillOptions) periods(g scheduler.Granularity, location *time.Location) ([]time.Time, error) {
+	from, err := parseBackfillTime(o.from, location)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	to, err := parseBackfillTime(o.to, location)
+	if err != nil {
+		return nil, fmt.Errorf("to: %w", err)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("from %s is after to %s", o.from, o.to)
+	}
+
+	var periods []time.Time
+	for p := g.Truncate(from); !p.After(to); p = g.Next(p) {
+		periods = append(periods, p)
+	}
+	return periods, nil
+}
+
+func parseBackfillTime(value string, location *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t.In(location), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected 2006-01-02, 2006-01-02T15:04 or RFC3339", value)
+}
+
+// backfill collects and delivers the billing records for each period.
+// All periods are attempted, the errors of failed periods are returned together.
+func backfill(ctx context.Context, periods []time.Time, collect func(context.Context, time.Time) ([]odoo.OdooMeteredBillingRecord, error), delivery *recordDelivery) error {
+	logger := log.Logger(ctx)
+
+	var errs []error
+	for _, period := range periods {
+		logger.Info("Backfilling billing records", "period", period)
+		if err := collectAndSend(ctx, period, collect, delivery); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", period, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+func TestBackfillOptions_periods(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	periods, err := backfillOptions{from: "2024-03-01", to: "2024-03-02"}.periods(scheduler.Daily, newYork)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 3, 1, 0, 0, 0, 0, newYork),
+		time.Date(2024, 3, 2, 0, 0, 0, 0, newYork),
+	}, periods)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/checkpoint"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+// defaultMaxLookback applies to the collectors whose sources keep historical usage.
+// Collectors which only see the current resources do not catch up by default, as they would bill today's resources for past periods.
+const defaultMaxLookback = 7 * 24 * time.Hour
+
+// maxRetryAge is how long failed periods are retried, independent of the maximum lookback
+const maxRetryAge = 7 * 24 * time.Hour
+
+// checkpointOptions holds the flags of the checkpoints, which let collectors catch up on the periods missed while they were down
+type checkpointOptions struct {
+	dir            string
+	maxLookback    time.Duration
+	maxLookbackSet bool
+}
+
+func (o *checkpointOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "checkpoint-dir", Usage: "Directory on a persistent volume where the last period sent by each collector is stored. Required unless running a preview or backfill",
+			EnvVars: []string{"CHECKPOINT_DIR"}, Destination: &o.dir},
+		&cli.DurationFlag{Name: "max-lookback", Usage: "How far back missed periods are caught up, older periods are skipped",
+			EnvVars: []string{"MAX_LOOKBACK"}, Destination: &o.maxLookback,
+			DefaultText: fmt.Sprintf("%s for collectors with historical usage, 0 for collectors of current resources", defaultMaxLookback),
+			Action: func(_ *cli.Context, _ time.Duration) error {
+				o.maxLookbackSet = true
+				return nil
+			}},
+	}
+}
+
+// collectorCheckpoint catches up on the periods a collector missed since its last successful run
+type collectorCheckpoint struct {
+	store       *checkpoint.Store
+	name        string
+	granularity scheduler.Granularity
+	maxLookback time.Duration
+	skipped     prometheus.Counter
+}
+
+// newCheckpoint creates the checkpoint of a collector.
+// Unless historical is set, the collector only sees the current resources and does not catch up on missed periods by default.
+func (o checkpointOptions) newCheckpoint(name string, g scheduler.Granularity, historical bool, collectorMetrics map[string]prometheus.Counter) (*collectorCheckpoint, error) {
+	if o.dir == "" {
+		return nil, errors.New("checkpoint: the checkpoint-dir flag pointing to a persistent volume is required, otherwise missed periods are not caught up after a restart")
+	}
+	store, err := checkpoint.NewStore(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	maxLookback := o.maxLookback
+	if !o.maxLookbackSet {
+		maxLookback = 0
+		if historical {
+			maxLookback = defaultMaxLookback
+		}
+	}
+	return &collectorCheckpoint{
+		store:       store,
+		name:        name,
+		granularity: g,
+		maxLookback: maxLookback,
+		skipped:     collectorMetrics["periodsSkipped"],
+	}, nil
+}
+
+// run collects and delivers the periods which failed before and every period since the checkpoint up to and including target.
+// Failed periods, including those which were only collected partially because some zones failed, are kept in the checkpoint
+// and retried by the next runs for at most maxRetryAge, independent of the maximum lookback.
+// Records which were delivered already are skipped by the ledger.
+func (cp *collectorCheckpoint) run(ctx context.Context, target time.Time, collect func(context.Context, time.Time) ([]odoo.OdooMeteredBillingRecord, error), delivery *recordDelivery) error {
+	logger := log.Logger(ctx)
+
+	state, ok, err := cp.store.Load(cp.name)
+	if err != nil {
+		return err
+	}
+	periods, skipped := checkpoint.Pending(state.Watermark, ok, target, cp.granularity, cp.maxLookback)
+	if skipped > 0 {
+		logger.Info("Skipping missed periods older than the maximum lookback, they are not billed", "collector", cp.name, "watermark", state.Watermark, "skippedPeriods", skipped, "maxLookback", cp.maxLookback)
+	}
+
+	oldestRetry := cp.granularity.Truncate(target.Add(-maxRetryAge))
+	retry := make([]time.Time, 0, len(state.Retry))
+	for _, period := range state.Retry {
+		// the checkpoint is stored in UTC, the collectors expect periods in the location of the schedule
+		period = period.In(target.Location())
+		if period.Before(oldestRetry) {
+			logger.Info("Giving up on failed period, it is not billed", "collector", cp.name, "period", period, "maxRetryAge", maxRetryAge)
+			skipped++
+			continue
+		}
+		if !slices.ContainsFunc(periods, period.Equal) {
+			retry = append(retry, period)
+		}
+	}
+	if skipped > 0 && cp.skipped != nil {
+		cp.skipped.Add(float64(skipped))
+	}
+	if len(retry) > 0 {
+		logger.Info("Retrying failed periods", "collector", cp.name, "periods", len(retry))
+	}
+	if len(periods) > 1 {
+		logger.Info("Catching up on missed periods", "collector", cp.name, "watermark", state.Watermark, "periods", len(periods))
+	}
+
+	var errs []error
+	var failed []time.Time
+	for i, period := range slices.Concat(retry, periods) {
+		if err := collectAndSend(ctx, period, collect, delivery); err != nil {
+			errs = append(errs, fmt.Errorf("period %s: %w", period, err))
+			failed = append(failed, period)
+		}
+		if i >= len(retry) {
+			state.Watermark = period
+		}
+		// the retries which have not been attempted yet stay in the checkpoint
+		state.Retry = slices.Concat(failed, retry[min(i+1, len(retry)):])
+		if err := cp.store.Save(cp.name, state); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runScheduled catches up on the periods missed while the collector was down and then runs at every activation of the schedule.
+// window returns the period billed at an activation, on startup all periods before the one of the next activation are caught up.
+// The ledger is read again first, as another replica may have been the leader since it was loaded.
+func runScheduled(ctx context.Context, s *scheduler.Scheduler, cp *collectorCheckpoint, window func(time.Time) time.Time, collect func(context.Context, time.Time) ([]odoo.OdooMeteredBillingRecord, error), delivery *recordDelivery) error {
+	logger := log.Logger(ctx)
+
+	if err := delivery.reload(); err != nil {
+		return err
+	}
+
+	_, ok, err := cp.store.Load(cp.name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if next := s.Next(); !next.IsZero() {
+			if err := cp.run(ctx, cp.granularity.Previous(window(next)), collect, delivery); err != nil {
+				logger.Error(err, "cannot catch up on missed periods", "collector", cp.name)
+			}
+		}
+	} else {
+		logger.Info("No checkpoint found, starting with the next scheduled period", "collector", cp.name)
+	}
+
+	return s.Run(ctx, func(ctx context.Context, tick time.Time) error {
+		return cp.run(ctx, window(tick), collect, delivery)
+	})
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/checkpoint"
+	"github.com/vshn/billing-collector-cloudservices/pkg/exoscale"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+func TestCollectorCheckpoint_runRetriesPartialZoneFailure(t *testing.T) {
+	ctx := log.NewLoggingContext(context.Background(), logr.Discard())
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+	at := func(hour int) time.Time {
+		return time.Date(2024, 3, 5, hour, 0, 0, 0, zurich)
+	}
+
+	store, err := checkpoint.NewStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Save("exoscale-dbaas", checkpoint.State{Watermark: at(9)}))
+	cp := &collectorCheckpoint{store: store, name: "exoscale-dbaas", granularity: scheduler.Hourly}
+
+	record := func(zone string, hour time.Time) odoo.OdooMeteredBillingRecord {
+		return odoo.OdooMeteredBillingRecord{InstanceID: zone + "/postgres-abc", TimeRange: odoo.TimeRange{From: hour, To: hour.Add(time.Hour)}}
+	}
+	gvaFailed := true
+	collect := func(_ context.Context, hour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+		if gvaFailed {
+			return []odoo.OdooMeteredBillingRecord{record("de-fra-1", hour)}, &exoscale.ZoneError{Failed: map[string]error{"ch-gva-2": assert.AnError}}
+		}
+		return []odoo.OdooMeteredBillingRecord{record("de-fra-1", hour), record("ch-gva-2", hour)}, nil
+	}
+	var sent []odoo.OdooMeteredBillingRecord
+	delivery := &recordDelivery{sink: odoo.SinkFunc(func(_ context.Context, data []odoo.OdooMeteredBillingRecord) error {
+		sent = append(sent, data...)
+		return nil
+	})}
+
+	// ch-gva-2 fails at 10:00, the answered zone is billed anyway
+	assert.Error(t, cp.run(ctx, at(10), collect, delivery))
+	assert.Equal(t, []odoo.OdooMeteredBillingRecord{record("de-fra-1", at(10))}, sent)
+	state, _, err := store.Load("exoscale-dbaas")
+	require.NoError(t, err)
+	assert.True(t, at(10).Equal(state.Watermark))
+	require.Len(t, state.Retry, 1)
+	assert.True(t, at(10).Equal(state.Retry[0]))
+
+	// the next run bills 10:00 again even without lookback, so the instances of ch-gva-2 are billed as well
+	gvaFailed = false
+	sent = nil
+	assert.NoError(t, cp.run(ctx, at(11), collect, delivery))
+	assert.Equal(t, []odoo.OdooMeteredBillingRecord{
+		record("de-fra-1", at(10)), record("ch-gva-2", at(10)),
+		record("de-fra-1", at(11)), record("ch-gva-2", at(11)),
+	}, sent)
+	state, _, err = store.Load("exoscale-dbaas")
+	require.NoError(t, err)
+	assert.True(t, at(11).Equal(state.Watermark))
+	assert.Empty(t, state.Retry)
+}
@@ -1,34 +1,34 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net/http"
-	"os"
-	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
 
 	"github.com/cloudscale-ch/cloudscale-go-sdk/v2"
 	"github.com/urfave/cli/v2"
 	cs "github.com/vshn/billing-collector-cloudservices/pkg/cloudscale"
 	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/orphans"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
 )
 
 const defaultTextForRequiredFlags = "<required>"
 const defaultTextForOptionalFlags = "<optional>"
 
-func CloudscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Command {
+func CloudscaleCmds(allMetrics map[string]map[string]prometheus.Counter, orphanedResources *prometheus.GaugeVec, unattributedUsage *prometheus.CounterVec) *cli.Command {
 	var (
 		apiToken          string
 		kubeconfig        string
 		controlApiUrl     string
 		controlApiToken   string
 		days              int
-		collectInterval   int
-		billingHour       int
 		odooURL           string
 		odooOauthTokenURL string
 		odooClientId      string
@@ -37,11 +37,103 @@ func CloudscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Co
 		clusterId         string
 		cloudZone         string
 		uom               string
+		deliveryOpts      deliveryOptions
+		preview           previewOptions
+		backfillOpts      backfillOptions
+		once              onceOptions
+		checkpointOpts    checkpointOptions
+		leaderElection    leaderElectionOptions
+		orphanOpts        orphanOptions
+		unattributedOpts  unattributedOptions
+		schedule          scheduleOptions
+		computeSchedule   scheduleOptions
+		legacySchedule    legacyScheduleOptions
 	)
+
+	newObjectStorage := func(c *cli.Context) (*cs.ObjectStorage, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = cs.CheckUnitExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("Creating cloudscale client")
+		cloudscaleClient := cloudscale.NewClient(http.DefaultClient)
+		cloudscaleClient.AuthToken = apiToken
+
+		logger.Info("Creating k8s client")
+		k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("k8s client: %w", err)
+		}
+
+		k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
+		if err != nil {
+			return nil, fmt.Errorf("k8s control client: %w", err)
+		}
+
+		policy, err := unattributedOpts.policy("cloudscale", unattributedUsage)
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := cs.NewObjectStorage(cloudscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, allMetrics["providerMetrics"], policy)
+		if err != nil {
+			return nil, fmt.Errorf("object storage: %w", err)
+		}
+		return o, nil
+	}
+
+	newCompute := func(c *cli.Context) (*cs.Compute, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = cs.CheckComputeUnitExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("Creating cloudscale client")
+		cloudscaleClient := cloudscale.NewClient(http.DefaultClient)
+		cloudscaleClient.AuthToken = apiToken
+
+		logger.Info("Creating k8s client")
+		k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("k8s client: %w", err)
+		}
+
+		k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
+		if err != nil {
+			return nil, fmt.Errorf("k8s control client: %w", err)
+		}
+
+		policy, err := unattributedOpts.policy("cloudscale", unattributedUsage)
+		if err != nil {
+			return nil, err
+		}
+
+		compute, err := cs.NewCompute(cloudscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, policy)
+		if err != nil {
+			return nil, fmt.Errorf("compute: %w", err)
+		}
+		return compute, nil
+	}
+
 	return &cli.Command{
 		Name:  "cloudscale",
 		Usage: "Collect metrics from cloudscale",
-		Flags: []cli.Flag{
+		Flags: concatFlags([]cli.Flag{
 			&cli.StringFlag{Name: "cloudscale-api-token", Usage: "API token for cloudscale",
 				EnvVars: []string{"CLOUDSCALE_API_TOKEN"}, Destination: &apiToken, Required: true, DefaultText: defaultTextForRequiredFlags},
 			&cli.StringFlag{Name: "kubeconfig", Usage: "Path to a kubeconfig file which will be used instead of url/token flags if set",
@@ -55,11 +147,11 @@ func CloudscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Co
 			&cli.StringFlag{Name: "odoo-url", Usage: "URL of the Odoo Metered Billing API",
 				EnvVars: []string{"ODOO_URL"}, Destination: &odooURL, Value: "http://localhost:8080"},
 			&cli.StringFlag{Name: "odoo-oauth-token-url", Usage: "Oauth Token URL to authenticate with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-id", Usage: "Client ID of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientId, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientId, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-secret", Usage: "Client secret of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "appuio-managed-sales-order", Usage: "Sales order id to save in the billing record for APPUiO Managed only",
 				EnvVars: []string{"APPUIO_MANAGED_SALES_ORDER"}, Destination: &salesOrder, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "cluster-id", Usage: "The cluster id to save in the billing record",
@@ -68,94 +160,168 @@ func CloudscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Co
 				EnvVars: []string{"CLOUD_ZONE"}, Destination: &cloudZone, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "uom", Usage: "Unit of measure mapping between cloud services and Odoo16 in json format",
 				EnvVars: []string{"UOM"}, Destination: &uom, Required: true, DefaultText: defaultTextForRequiredFlags},
-			&cli.IntFlag{Name: "collect-interval", Usage: "How often to collect the metrics from the Cloud Service in hours - 1-23",
-				EnvVars: []string{"COLLECT_INTERVAL"}, Destination: &collectInterval, Required: true, DefaultText: defaultTextForRequiredFlags},
-			&cli.IntFlag{Name: "billing-hour", Usage: "At what time to start collect the metrics (ex 6 would start running from 6)",
-				EnvVars: []string{"BILLING_HOUR"}, Destination: &billingHour, Required: true, DefaultText: defaultTextForRequiredFlags},
-		},
+		}, deliveryOpts.flags(), checkpointOpts.flags(), preview.flags(), once.flags(), schedule.flags("0 6 * * *"), leaderElection.flags(),
+			// usage of our VSHN services has always been billed to the vshn organization
+			unattributedOpts.flags(unattributed.BillOrganization, "vshn"), legacySchedule.flags()),
 		Before: addCommandName,
 		Action: func(c *cli.Context) error {
 			logger := log.Logger(c.Context)
-			var wg sync.WaitGroup
 
-			logger.Info("Checking UOM mappings")
-			mapping, err := odoo.LoadUOM(uom)
+			o, err := newObjectStorage(c)
 			if err != nil {
 				return err
 			}
-			err = cs.CheckUnitExistence(mapping)
-			if err != nil {
+
+			if err := schedule.applyLegacy(c, legacySchedule, true); err != nil {
 				return err
 			}
-
-			logger.Info("Creating cloudscale client")
-			cloudscaleClient := cloudscale.NewClient(http.DefaultClient)
-			cloudscaleClient.AuthToken = apiToken
-
-			logger.Info("Creating k8s client")
-			k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
+			s, err := schedule.newScheduler()
 			if err != nil {
-				return fmt.Errorf("k8s client: %w", err)
+				return fmt.Errorf("scheduler: %w", err)
 			}
 
-			k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
-			if err != nil {
-				return fmt.Errorf("k8s control client: %w", err)
+			getBillingDate := func(t time.Time) time.Time {
+				billingDate := t.In(s.Location())
+				if days != 0 {
+					billingDate = time.Date(billingDate.Year(), billingDate.Month(), billingDate.Day()-days, 0, 0, 0, 0, billingDate.Location())
+				}
+				return billingDate
 			}
 
-			odooClient := odoo.NewOdooAPIClient(c.Context, odooURL, odooOauthTokenURL, odooClientId, odooClientSecret, logger, allMetrics["odooMetrics"])
+			if preview.enabled {
+				metrics, err := o.GetMetrics(c.Context, getBillingDate(time.Now()))
+				if err != nil {
+					return fmt.Errorf("could not collect cloudscale bucket metrics: %w", err)
+				}
+				return preview.print(metrics)
+			}
 
-			location, err := time.LoadLocation("Europe/Zurich")
+			delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], logger)
 			if err != nil {
-				return fmt.Errorf("load loaction: %w", err)
+				return err
 			}
 
-			o, err := cs.NewObjectStorage(cloudscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, allMetrics["providerMetrics"])
+			cp, err := checkpointOpts.newCheckpoint("cloudscale", scheduler.Daily, true, allMetrics["collectorMetrics"])
 			if err != nil {
-				return fmt.Errorf("object storage: %w", err)
+				return err
+			}
+			billingDay := func(t time.Time) time.Time {
+				return scheduler.Daily.Truncate(getBillingDate(t))
 			}
 
-			if collectInterval < 1 || collectInterval > 23 {
-				// Set to run once a day after billingHour in case the collectInterval is out of boundaries
-				collectInterval = 23
+			if once.enabled {
+				return cp.run(c.Context, billingDay(time.Now()), o.GetMetrics, delivery)
 			}
 
-			wg.Add(1)
-			go func() {
-				for {
-					if time.Now().Hour() >= billingHour {
+			return leaderElection.run(c.Context, kubeconfig, "billing-collector-cloudscale", func(ctx context.Context) error {
+				return runScheduled(ctx, s, cp, billingDay, o.GetMetrics, delivery)
+			})
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:   "backfill",
+				Usage:  "Send the cloudscale object storage billing records of every day in a date range",
+				Before: addCommandName,
+				Flags:  backfillOpts.flags(),
+				Action: func(c *cli.Context) error {
+					location, err := schedule.location()
+					if err != nil {
+						return err
+					}
+					periods, err := backfillOpts.periods(scheduler.Daily, location)
+					if err != nil {
+						return err
+					}
 
-						billingDate := time.Now().In(location)
-						if days != 0 {
-							billingDate = time.Date(billingDate.Year(), billingDate.Month(), billingDate.Day()-days, 0, 0, 0, 0, billingDate.Location())
-						}
+					o, err := newObjectStorage(c)
+					if err != nil {
+						return err
+					}
 
-						logger.V(1).Info("Running cloudscale collector")
-						metrics, err := o.GetMetrics(c.Context, billingDate)
-						if err != nil {
-							logger.Error(err, "could not collect cloudscale bucket metrics")
-							wg.Done()
-						}
+					delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], log.Logger(c.Context))
+					if err != nil {
+						return err
+					}
 
-						if len(metrics) == 0 {
-							logger.Info("No data to export to odoo", "date", billingDate)
-							time.Sleep(time.Hour)
-							continue
-						}
+					// cloudscale returns the metrics of all days in a single request
+					collectRange := func(ctx context.Context, _ time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+						return o.GetMetricsRange(ctx, periods[0], periods[len(periods)-1])
+					}
+					return collectAndSend(c.Context, periods[0], collectRange, delivery)
+				},
+			},
+			{
+				Name:   "compute",
+				Usage:  "Get metrics from servers, volumes and floating IPs tagged with the cluster id",
+				Before: addCommandName,
+				Flags:  computeSchedule.flags("0 * * * *"),
+				Action: func(c *cli.Context) error {
+					logger := log.Logger(c.Context)
+
+					compute, err := newCompute(c)
+					if err != nil {
+						return err
+					}
+
+					s, err := computeSchedule.newScheduler()
+					if err != nil {
+						return fmt.Errorf("scheduler: %w", err)
+					}
+					// cloudscale only reports the current resources, which are billed for the hour of the activation
+					billingHour := func(t time.Time) time.Time {
+						return scheduler.Hourly.Truncate(t.In(s.Location()))
+					}
 
-						logger.Info("Exporting data to Odoo", "billingHour", billingHour, "date", billingDate)
-						err = odooClient.SendData(metrics)
+					if preview.enabled {
+						metrics, err := compute.GetMetrics(c.Context, billingHour(time.Now()))
 						if err != nil {
-							logger.Error(err, "could not export cloudscale bucket metrics")
+							return fmt.Errorf("could not collect cloudscale compute metrics: %w", err)
 						}
-						time.Sleep(time.Hour * time.Duration(collectInterval))
+						return preview.print(metrics)
 					}
-					time.Sleep(time.Hour)
-				}
-			}()
-			wg.Wait()
-			os.Exit(1)
-			return nil
+
+					delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], logger)
+					if err != nil {
+						return err
+					}
+
+					cp, err := checkpointOpts.newCheckpoint("cloudscale-compute", scheduler.Hourly, false, allMetrics["collectorMetrics"])
+					if err != nil {
+						return err
+					}
+
+					if once.enabled {
+						return cp.run(c.Context, billingHour(time.Now()), compute.GetMetrics, delivery)
+					}
+
+					return leaderElection.run(c.Context, kubeconfig, "billing-collector-cloudscale-compute", func(ctx context.Context) error {
+						return runScheduled(ctx, s, cp, billingHour, compute.GetMetrics, delivery)
+					})
+				},
+			},
+			{
+				Name:   "orphans",
+				Usage:  "Report cloudscale buckets which have no matching object in the cluster",
+				Before: addCommandName,
+				Flags:  orphanOpts.flags(),
+				Action: func(c *cli.Context) error {
+					o, err := newObjectStorage(c)
+					if err != nil {
+						return err
+					}
+
+					location, err := time.LoadLocation(scheduler.DefaultTimezone)
+					if err != nil {
+						return fmt.Errorf("load location: %w", err)
+					}
+					// bucket metrics are only available for complete days
+					checkYesterday := func(ctx context.Context) ([]string, []orphans.Resource, error) {
+						return o.FindOrphans(ctx, scheduler.Daily.Previous(time.Now().In(location)))
+					}
+
+					return orphanOpts.run(c.Context, once.enabled, "cloudscale", orphanedResources, checkYesterday)
+				},
+			},
 		},
 	}
 }
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+)
+
+const (
+	sinkOdoo   = "odoo"
+	sinkFile   = "file"
+	sinkStdout = "stdout"
+)
+
+const (
+	defaultOdooMaxRecordsPerRequest = 500
+	defaultOdooMaxBytesPerRequest   = 1024 * 1024
+)
+
+// defaultLedgerRetention is how long delivered records are remembered to suppress duplicates
+const defaultLedgerRetention = 90 * 24 * time.Hour
+
+// deliveryOptions holds the flags controlling how billing records are delivered
+type deliveryOptions struct {
+	sink            string
+	sinkFile        string
+	outboxDir       string
+	ledgerFile      string
+	ledgerRetention time.Duration
+	forceResend     bool
+	odooMaxRecords  int
+	odooMaxBytes    int
+}
+
+func (o *deliveryOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "sink", Usage: fmt.Sprintf("Where to send the billing records to (values: [%s, %s, %s])", sinkOdoo, sinkFile, sinkStdout),
+			EnvVars: []string{"SINK"}, Destination: &o.sink, Value: sinkOdoo,
+			Action: func(c *cli.Context, s string) error {
+				if s != sinkOdoo && s != sinkFile && s != sinkStdout {
+					return fmt.Errorf("invalid sink %q, needs to be one of %s, %s or %s", s, sinkOdoo, sinkFile, sinkStdout)
+				}
+				return nil
+			}},
+		&cli.StringFlag{Name: "sink-file", Usage: "Path of the JSON lines file the records are appended to when using the file sink",
+			EnvVars: []string{"SINK_FILE"}, Destination: &o.sinkFile, Value: "billing-records.jsonl"},
+		&cli.StringFlag{Name: "outbox-dir", Usage: "Directory on a persistent volume where billing records are stored until Odoo accepted them. Required for the odoo sink",
+			EnvVars: []string{"OUTBOX_DIR"}, Destination: &o.outboxDir},
+		&cli.StringFlag{Name: "ledger-file", Usage: "File on a persistent volume remembering all delivered billing records to suppress duplicates. Required for the odoo sink",
+			EnvVars: []string{"LEDGER_FILE"}, Destination: &o.ledgerFile},
+		&cli.DurationFlag{Name: "ledger-retention", Usage: "How long delivered billing records are remembered. Records of older periods are refused, as they cannot be checked for duplicates",
+			EnvVars: []string{"LEDGER_RETENTION"}, Destination: &o.ledgerRetention, Value: defaultLedgerRetention},
+		&cli.BoolFlag{Name: "force-resend", Usage: "Send billing records again even if they have already been delivered, e.g. for corrections",
+			EnvVars: []string{"FORCE_RESEND"}, Destination: &o.forceResend},
+		&cli.IntFlag{Name: "odoo-max-records-per-request", Usage: "Maximum number of billing records sent to Odoo in one request, set to 0 to disable the limit",
+			EnvVars: []string{"ODOO_MAX_RECORDS_PER_REQUEST"}, Destination: &o.odooMaxRecords, Value: defaultOdooMaxRecordsPerRequest},
+		&cli.IntFlag{Name: "odoo-max-bytes-per-request", Usage: "Maximum payload size in bytes of one request to Odoo, set to 0 to disable the limit",
+			EnvVars: []string{"ODOO_MAX_BYTES_PER_REQUEST"}, Destination: &o.odooMaxBytes, Value: defaultOdooMaxBytesPerRequest},
+	}
+}
+
+// odooConfig holds the connection settings of the Odoo metered billing API
+type odooConfig struct {
+	url               string
+	oauthTokenURL     string
+	oauthClientID     string
+	oauthClientSecret string
+}
+
+// newSink creates the sink selected by the flags
+func (o deliveryOptions) newSink(ctx context.Context, cfg odooConfig, odooMetrics map[string]prometheus.Counter, logger logr.Logger) (odoo.Sink, error) {
+	switch o.sink {
+	case sinkFile:
+		return odoo.NewFileSink(o.sinkFile)
+	case sinkStdout:
+		return odoo.NewStdoutSink(), nil
+	default:
+		if cfg.oauthTokenURL == "" || cfg.oauthClientID == "" || cfg.oauthClientSecret == "" {
+			return nil, fmt.Errorf("the %s sink requires the odoo-oauth-token-url, odoo-oauth-client-id and odoo-oauth-client-secret flags", sinkOdoo)
+		}
+		return odoo.NewOdooAPIClient(ctx, cfg.url, cfg.oauthTokenURL, cfg.oauthClientID, cfg.oauthClientSecret, logger, odooMetrics,
+			odoo.WithChunkLimits(o.odooMaxRecords, o.odooMaxBytes)), nil
+	}
+}
+
+// recordDelivery gets billing records to their sink.
+// Records for Odoo are delivered exactly once: they are stored in the outbox first,
+// then sent to Odoo, skipping everything the ledger knows as delivered.
+// The other sinks receive the records directly, so dry-runs never touch the outbox or ledger used for billing.
+type recordDelivery struct {
+	outbox *odoo.Outbox
+	ledger *odoo.Ledger
+	sink   odoo.Sink
+}
+
+func newDelivery(ctx context.Context, opts deliveryOptions, cfg odooConfig, odooMetrics map[string]prometheus.Counter, logger logr.Logger) (*recordDelivery, error) {
+	sink, err := opts.newSink(ctx, cfg, odooMetrics, logger)
+	if err != nil {
+		return nil, fmt.Errorf("sink: %w", err)
+	}
+	if opts.sink != sinkOdoo {
+		logger.Info("Sending billing records to sink", "sink", opts.sink)
+		return &recordDelivery{sink: sink}, nil
+	}
+
+	if opts.outboxDir == "" {
+		return nil, fmt.Errorf("the %s sink requires the outbox-dir flag pointing to a persistent volume, otherwise undelivered records are lost on restart", sinkOdoo)
+	}
+	outbox, err := odoo.NewOutbox(opts.outboxDir, logger, odooMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("outbox: %w", err)
+	}
+	if opts.ledgerFile == "" {
+		return nil, fmt.Errorf("the %s sink requires the ledger-file flag pointing to a persistent volume, otherwise records are billed twice after a restart", sinkOdoo)
+	}
+	ledger, err := odoo.NewLedger(opts.ledgerFile, opts.ledgerRetention, opts.forceResend, logger)
+	if err != nil {
+		return nil, fmt.Errorf("ledger: %w", err)
+	}
+	return &recordDelivery{
+		outbox: outbox,
+		ledger: ledger,
+		sink:   ledger.Wrap(sink),
+	}, nil
+}
+
+// sendRecords stores the records in the outbox first and then delivers all pending batches.
+// Records older than the retention of the ledger are refused, as they might have been delivered already.
+func (d *recordDelivery) sendRecords(ctx context.Context, records []odoo.OdooMeteredBillingRecord) error {
+	if d.outbox == nil {
+		return d.sink.SendData(ctx, records)
+	}
+	if expired := d.ledger.Expired(records); len(expired) > 0 {
+		return fmt.Errorf("%d records end before the retention of the ledger and cannot be checked for duplicates, e.g. %s of %s, set force-resend to send them anyway",
+			len(expired), expired[0].InstanceID, expired[0].TimeRange.From)
+	}
+	if err := d.outbox.Enqueue(records); err != nil {
+		return err
+	}
+	return d.flush(ctx)
+}
+
+// reload reads the ledger again, so records delivered by another replica while this one was on standby are not sent twice
+func (d *recordDelivery) reload() error {
+	if d.ledger == nil {
+		return nil
+	}
+	return d.ledger.Reload()
+}
+
+// flush delivers all pending batches from the outbox
+func (d *recordDelivery) flush(ctx context.Context) error {
+	if d.outbox == nil {
+		return nil
+	}
+	return d.outbox.Flush(ctx, d.sink)
+}
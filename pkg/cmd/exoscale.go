@@ -1,18 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
 
+	egoscale "github.com/exoscale/egoscale/v3"
 	"github.com/urfave/cli/v2"
 	"github.com/vshn/billing-collector-cloudservices/pkg/exoscale"
 	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func addCommandName(c *cli.Context) error {
@@ -20,7 +23,12 @@ func addCommandName(c *cli.Context) error {
 	return nil
 }
 
-func ExoscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Command {
+// metricsCollector collects the billing records of a period
+type metricsCollector interface {
+	GetMetrics(ctx context.Context, period time.Time) ([]odoo.OdooMeteredBillingRecord, error)
+}
+
+func ExoscaleCmds(allMetrics map[string]map[string]prometheus.Counter, zoneMetrics map[string]*prometheus.CounterVec, orphanedResources *prometheus.GaugeVec, unattributedUsage *prometheus.CounterVec) *cli.Command {
 	var (
 		secret            string
 		accessKey         string
@@ -35,16 +43,261 @@ func ExoscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Comm
 		clusterId         string
 		cloudZone         string
 		uom               string
-		// For dbaas in minutes
-		// For objectstorage in hours
-		// TODO: Fix this mess
-		collectInterval int
-		billingHour     int
+		deliveryOpts      deliveryOptions
+		preview           previewOptions
+		once              onceOptions
+		checkpointOpts    checkpointOptions
+		leaderElection    leaderElectionOptions
+		orphanOpts        orphanOptions
+		unattributedOpts  unattributedOptions
+		zones             cli.StringSlice
+		zoneWorkers       int
+		zoneTimeout       time.Duration
+		legacySchedule    legacyScheduleOptions
+		billExtraDisk     bool
+		billBackups       bool
+
+		objectStorageSchedule scheduleOptions
+		dbaasSchedule         scheduleOptions
+		computeSchedule       scheduleOptions
+		sksSchedule           scheduleOptions
+		nlbSchedule           scheduleOptions
 	)
+
+	newClients := func(c *cli.Context) (*egoscale.Client, k8s.Client, k8s.Client, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Creating Exoscale client")
+		exoscaleClient, err := exoscale.NewClient(accessKey, secret)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("exoscale client: %w", err)
+		}
+
+		logger.Info("Creating k8s client")
+		k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("k8s client: %w", err)
+		}
+
+		k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("k8s control client: %w", err)
+		}
+		return exoscaleClient, k8sClient, k8sControlClient, nil
+	}
+
+	newObjectStorage := func(c *cli.Context) (*exoscale.ObjectStorage, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = exoscale.CheckObjectStorageUOMExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		exoscaleClient, k8sClient, k8sControlClient, err := newClients(c)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := unattributedOpts.policy("exoscale", unattributedUsage)
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := exoscale.NewObjectStorage(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, allMetrics["providerMetrics"], policy)
+		if err != nil {
+			return nil, fmt.Errorf("objectbucket service: %w", err)
+		}
+		return o, nil
+	}
+
+	newDBaaS := func(c *cli.Context) (*exoscale.DBaaS, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = exoscale.CheckDBaaSUOMExistence(mapping, billExtraDisk || billBackups)
+		if err != nil {
+			return nil, err
+		}
+
+		exoscaleClient, k8sClient, k8sControlClient, err := newClients(c)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := unattributedOpts.policy("exoscale", unattributedUsage)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := exoscale.NewDBaaS(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, exoscale.ZoneFetcher{
+			Zones:   zones.Value(),
+			Workers: zoneWorkers,
+			Timeout: zoneTimeout,
+			Metrics: zoneMetrics,
+		}, policy, billExtraDisk, billBackups)
+		if err != nil {
+			return nil, fmt.Errorf("dbaas service: %w", err)
+		}
+		return d, nil
+	}
+
+	newCompute := func(c *cli.Context) (*exoscale.Compute, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = exoscale.CheckComputeUOMExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		exoscaleClient, k8sClient, k8sControlClient, err := newClients(c)
+		if err != nil {
+			return nil, err
+		}
+
+		compute, err := exoscale.NewCompute(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, exoscale.ZoneFetcher{
+			Zones:   zones.Value(),
+			Workers: zoneWorkers,
+			Timeout: zoneTimeout,
+			Metrics: zoneMetrics,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("compute service: %w", err)
+		}
+		return compute, nil
+	}
+
+	newSKS := func(c *cli.Context) (*exoscale.SKS, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = exoscale.CheckInstanceHourUOMExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		exoscaleClient, k8sClient, k8sControlClient, err := newClients(c)
+		if err != nil {
+			return nil, err
+		}
+
+		sks, err := exoscale.NewSKS(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, exoscale.ZoneFetcher{
+			Zones:   zones.Value(),
+			Workers: zoneWorkers,
+			Timeout: zoneTimeout,
+			Metrics: zoneMetrics,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("sks service: %w", err)
+		}
+		return sks, nil
+	}
+
+	newNLB := func(c *cli.Context) (*exoscale.NLB, error) {
+		logger := log.Logger(c.Context)
+
+		logger.Info("Checking UOM mappings")
+		mapping, err := odoo.LoadUOM(uom)
+		if err != nil {
+			return nil, err
+		}
+		err = exoscale.CheckInstanceHourUOMExistence(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		exoscaleClient, k8sClient, k8sControlClient, err := newClients(c)
+		if err != nil {
+			return nil, err
+		}
+
+		nlb, err := exoscale.NewNLB(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, exoscale.ZoneFetcher{
+			Zones:   zones.Value(),
+			Workers: zoneWorkers,
+			Timeout: zoneTimeout,
+			Metrics: zoneMetrics,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("nlb service: %w", err)
+		}
+		return nlb, nil
+	}
+
+	// hourlyCmd creates the subcommand of a collector which bills the resources running at the activation for the hour of the activation
+	hourlyCmd := func(name, usage string, schedule *scheduleOptions, newCollector func(c *cli.Context) (metricsCollector, error)) *cli.Command {
+		return &cli.Command{
+			Name:   name,
+			Usage:  usage,
+			Before: addCommandName,
+			Flags:  schedule.flags("0 * * * *"),
+			Action: func(c *cli.Context) error {
+				logger := log.Logger(c.Context)
+
+				collector, err := newCollector(c)
+				if err != nil {
+					return err
+				}
+
+				s, err := schedule.newScheduler()
+				if err != nil {
+					return fmt.Errorf("scheduler: %w", err)
+				}
+				// Exoscale only reports the current resources, which are billed for the hour of the activation
+				billingHour := func(t time.Time) time.Time {
+					return scheduler.Hourly.Truncate(t.In(s.Location()))
+				}
+
+				if preview.enabled {
+					metrics, err := collector.GetMetrics(c.Context, billingHour(time.Now()))
+					if err != nil {
+						return fmt.Errorf("%s collector: %w", name, err)
+					}
+					return preview.print(metrics)
+				}
+
+				delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], logger)
+				if err != nil {
+					return err
+				}
+
+				cp, err := checkpointOpts.newCheckpoint("exoscale-"+name, scheduler.Hourly, false, allMetrics["collectorMetrics"])
+				if err != nil {
+					return err
+				}
+
+				if once.enabled {
+					return cp.run(c.Context, billingHour(time.Now()), collector.GetMetrics, delivery)
+				}
+
+				return leaderElection.run(c.Context, kubeconfig, "billing-collector-exoscale-"+name, func(ctx context.Context) error {
+					return runScheduled(ctx, s, cp, billingHour, collector.GetMetrics, delivery)
+				})
+			},
+		}
+	}
+
 	return &cli.Command{
 		Name:  "exoscale",
 		Usage: "Collect metrics from exoscale",
-		Flags: []cli.Flag{
+		Flags: concatFlags([]cli.Flag{
 			&cli.StringFlag{Name: "exoscale-secret", Aliases: []string{"s"}, Usage: "The secret which has unrestricted SOS service access in an Exoscale organization",
 				EnvVars: []string{"EXOSCALE_API_SECRET"}, Destination: &secret, Required: true, DefaultText: defaultTextForRequiredFlags},
 			&cli.StringFlag{Name: "exoscale-access-key", Aliases: []string{"k"}, Usage: "A key which has unrestricted SOS service access in an Exoscale organization",
@@ -58,181 +311,162 @@ func ExoscaleCmds(allMetrics map[string]map[string]prometheus.Counter) *cli.Comm
 			&cli.StringFlag{Name: "odoo-url", Usage: "URL of the Odoo Metered Billing API",
 				EnvVars: []string{"ODOO_URL"}, Destination: &odooURL, Value: "http://localhost:8080"},
 			&cli.StringFlag{Name: "odoo-oauth-token-url", Usage: "Oauth Token URL to authenticate with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-id", Usage: "Client ID of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientId, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientId, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-secret", Usage: "Client secret of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "appuio-managed-sales-order", Usage: "Sales order for APPUiO Managed clusters",
 				EnvVars: []string{"APPUIO_MANAGED_SALES_ORDER"}, Destination: &salesOrder, Required: false, DefaultText: defaultTextForOptionalFlags},
-			&cli.IntFlag{Name: "collect-interval", Usage: "How often to collect the metrics from the Cloud Service in hours - 1-23",
-				EnvVars: []string{"COLLECT_INTERVAL"}, Destination: &collectInterval, Required: true, DefaultText: defaultTextForRequiredFlags},
-			&cli.IntFlag{Name: "billing-hour", Usage: "At what time to start collect the metrics (ex 6 would start running from 6)",
-				EnvVars: []string{"BILLING_HOUR"}, Destination: &billingHour, Required: false, DefaultText: defaultTextForOptionalFlags},
+			&cli.StringSliceFlag{Name: "exoscale-zones", Usage: "Exoscale zones to collect the metrics from",
+				EnvVars: []string{"EXOSCALE_ZONES"}, Destination: &zones, Value: cli.NewStringSlice(exoscale.DefaultZones...)},
+			&cli.IntFlag{Name: "exoscale-zone-workers", Usage: "How many Exoscale zones are fetched at the same time",
+				EnvVars: []string{"EXOSCALE_ZONE_WORKERS"}, Destination: &zoneWorkers, Value: exoscale.DefaultZoneWorkers},
+			&cli.DurationFlag{Name: "exoscale-zone-timeout", Usage: "Timeout of fetching a single Exoscale zone",
+				EnvVars: []string{"EXOSCALE_ZONE_TIMEOUT"}, Destination: &zoneTimeout, Value: exoscale.DefaultZoneTimeout},
 			&cli.StringFlag{Name: "cluster-id", Usage: "The cluster id to save in the billing record",
 				EnvVars: []string{"CLUSTER_ID"}, Destination: &clusterId, Required: true, DefaultText: defaultTextForRequiredFlags},
 			&cli.StringFlag{Name: "cluster-zone", Usage: "The cluster zone to save in the billing record",
 				EnvVars: []string{"CLOUD_ZONE"}, Destination: &cloudZone, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "uom", Usage: "Unit of measure mapping between cloud services and Odoo16 in json format",
 				EnvVars: []string{"UOM"}, Destination: &uom, Required: true, DefaultText: defaultTextForRequiredFlags},
-		},
+		}, deliveryOpts.flags(), checkpointOpts.flags(), preview.flags(), once.flags(), leaderElection.flags(), unattributedOpts.flags(unattributed.Skip, ""), legacySchedule.flags()),
 		Before: addCommandName,
 		Subcommands: []*cli.Command{
 			{
 				Name:   "objectstorage",
 				Usage:  "Get metrics from object storage service",
 				Before: addCommandName,
+				Flags:  objectStorageSchedule.flags("0 6 * * *"),
 				Action: func(c *cli.Context) error {
 					logger := log.Logger(c.Context)
 
-					var wg sync.WaitGroup
-					logger.Info("Creating Exoscale client")
-					exoscaleClient, err := exoscale.NewClient(accessKey, secret)
+					o, err := newObjectStorage(c)
 					if err != nil {
-						return fmt.Errorf("exoscale client: %w", err)
+						return err
 					}
 
-					logger.Info("Checking UOM mappings")
-					mapping, err := odoo.LoadUOM(uom)
-					if err != nil {
+					if err := objectStorageSchedule.applyLegacy(c, legacySchedule, true); err != nil {
 						return err
 					}
-					err = exoscale.CheckObjectStorageUOMExistence(mapping)
+					s, err := objectStorageSchedule.newScheduler()
 					if err != nil {
-						return err
+						return fmt.Errorf("scheduler: %w", err)
+					}
+					// Exoscale only reports the current usage, which is billed for the day before the activation
+					billingDay := func(t time.Time) time.Time {
+						return scheduler.Daily.Previous(t.In(s.Location()))
 					}
 
-					logger.Info("Creating k8s client")
-					k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
-					if err != nil {
-						return fmt.Errorf("k8s client: %w", err)
+					if preview.enabled {
+						metrics, err := o.GetMetrics(c.Context, billingDay(time.Now()))
+						if err != nil {
+							return fmt.Errorf("objectstorage collector: %w", err)
+						}
+						return preview.print(metrics)
 					}
 
-					k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
+					delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], logger)
 					if err != nil {
-						return fmt.Errorf("k8s control client: %w", err)
+						return err
 					}
 
-					odooClient := odoo.NewOdooAPIClient(c.Context, odooURL, odooOauthTokenURL, odooClientId, odooClientSecret, logger, allMetrics["odooMetrics"])
-
-					if collectInterval < 1 || collectInterval > 23 {
-						// Set to run once a day after billingHour in case the collectInterval is out of boundaries
-						collectInterval = 23
+					cp, err := checkpointOpts.newCheckpoint("exoscale-objectstorage", scheduler.Daily, false, allMetrics["collectorMetrics"])
+					if err != nil {
+						return err
 					}
 
-					o, err := exoscale.NewObjectStorage(exoscaleClient, k8sClient, k8sControlClient, salesOrder, clusterId, cloudZone, mapping, allMetrics["providerMetrics"])
-					if err != nil {
-						return fmt.Errorf("objectbucket service: %w", err)
-					}
-
-					wg.Add(1)
-					go func() {
-						for {
-							if time.Now().Hour() >= billingHour {
-
-								logger.Info("Collecting ObjectStorage metrics after", "hour", billingHour)
-
-								metrics, err := o.GetMetrics(c.Context)
-								if err != nil {
-									logger.Error(err, "cannot execute objectstorage collector")
-									wg.Done()
-								}
-								if len(metrics) == 0 {
-									logger.Info("No data to export to odoo")
-									time.Sleep(time.Hour)
-									continue
-								}
-								logger.Info("Exporting data to Odoo", "time", time.Now())
-								err = odooClient.SendData(metrics)
-								if err != nil {
-									logger.Error(err, "cannot export metrics")
-								}
-								time.Sleep(time.Hour * time.Duration(collectInterval))
-							}
-							time.Sleep(time.Hour)
-						}
-					}()
+					if once.enabled {
+						return cp.run(c.Context, billingDay(time.Now()), o.GetMetrics, delivery)
+					}
 
-					wg.Wait()
-					os.Exit(1)
-					return nil
+					return leaderElection.run(c.Context, kubeconfig, "billing-collector-exoscale-objectstorage", func(ctx context.Context) error {
+						return runScheduled(ctx, s, cp, billingDay, o.GetMetrics, delivery)
+					})
 				},
 			},
 			{
 				Name:   "dbaas",
 				Usage:  "Get metrics from database service",
 				Before: addCommandName,
+				Flags: concatFlags(dbaasSchedule.flags("0 * * * *"), []cli.Flag{
+					&cli.BoolFlag{Name: "bill-extra-disk", Usage: "Bill the disk beyond the disk of the plan in GiB per hour, requires a GBHour UOM mapping",
+						EnvVars: []string{"DBAAS_BILL_EXTRA_DISK"}, Destination: &billExtraDisk, Value: true},
+					&cli.BoolFlag{Name: "bill-backups", Usage: "Bill the backups in GiB per hour, requires a GBHour UOM mapping",
+						EnvVars: []string{"DBAAS_BILL_BACKUPS"}, Destination: &billBackups, Value: true},
+				}),
 				Action: func(c *cli.Context) error {
 					logger := log.Logger(c.Context)
 
-					var wg sync.WaitGroup
-					logger.Info("Creating Exoscale client")
-					exoscaleClient, err := exoscale.NewClient(accessKey, secret)
+					d, err := newDBaaS(c)
 					if err != nil {
-						return fmt.Errorf("exoscale client: %w", err)
+						return err
 					}
 
-					logger.Info("Checking UOM mappings")
-					mapping, err := odoo.LoadUOM(uom)
-					if err != nil {
+					if err := dbaasSchedule.applyLegacy(c, legacySchedule, false); err != nil {
 						return err
 					}
-					err = exoscale.CheckDBaaSUOMExistence(mapping)
+					s, err := dbaasSchedule.newScheduler()
 					if err != nil {
-						return err
+						return fmt.Errorf("scheduler: %w", err)
+					}
+					// Exoscale only reports the running services, which are billed for the hour of the activation
+					billingHour := func(t time.Time) time.Time {
+						return scheduler.Hourly.Truncate(t.In(s.Location()))
 					}
 
-					logger.Info("Creating k8s client")
-					k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
-					if err != nil {
-						return fmt.Errorf("k8s client: %w", err)
+					if preview.enabled {
+						metrics, err := d.GetMetrics(c.Context, billingHour(time.Now()))
+						if err != nil {
+							return fmt.Errorf("dbaas collector: %w", err)
+						}
+						return preview.print(metrics)
 					}
 
-					k8sControlClient, err := kubernetes.NewClient("", controlApiUrl, controlApiToken)
+					delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientId, odooClientSecret}, allMetrics["odooMetrics"], logger)
 					if err != nil {
-						return fmt.Errorf("k8s control client: %w", err)
+						return err
 					}
 
-					odooClient := odoo.NewOdooAPIClient(c.Context, odooURL, odooOauthTokenURL, odooClientId, odooClientSecret, logger, allMetrics["odooMetrics"])
+					cp, err := checkpointOpts.newCheckpoint("exoscale-dbaas", scheduler.Hourly, false, allMetrics["collectorMetrics"])
+					if err != nil {
+						return err
+					}
 
-					if collectInterval < 1 || collectInterval > 24 {
-						// Set to run once a day after billingHour in case the collectInterval is out of boundaries
-						collectInterval = 1
+					if once.enabled {
+						return cp.run(c.Context, billingHour(time.Now()), d.GetMetrics, delivery)
 					}
 
-					d, err := exoscale.NewDBaaS(exoscaleClient, k8sClient, k8sControlClient, collectInterval, salesOrder, clusterId, cloudZone, mapping)
+					return leaderElection.run(c.Context, kubeconfig, "billing-collector-exoscale-dbaas", func(ctx context.Context) error {
+						return runScheduled(ctx, s, cp, billingHour, d.GetMetrics, delivery)
+					})
+				},
+			},
+			hourlyCmd("compute", "Get metrics from compute instances and block storage volumes labelled with the cluster id", &computeSchedule, func(c *cli.Context) (metricsCollector, error) {
+				return newCompute(c)
+			}),
+			hourlyCmd("sks", "Get metrics from SKS clusters labelled with the cluster id", &sksSchedule, func(c *cli.Context) (metricsCollector, error) {
+				return newSKS(c)
+			}),
+			hourlyCmd("nlb", "Get metrics from Network Load Balancers labelled with the cluster id", &nlbSchedule, func(c *cli.Context) (metricsCollector, error) {
+				return newNLB(c)
+			}),
+			{
+				Name:   "orphans",
+				Usage:  "Report Exoscale DBaaS services and SOS buckets which have no matching object in the cluster",
+				Before: addCommandName,
+				Flags:  orphanOpts.flags(),
+				Action: func(c *cli.Context) error {
+					o, err := newObjectStorage(c)
 					if err != nil {
-						return fmt.Errorf("dbaas service: %w", err)
-					}
-
-					wg.Add(1)
-					go func() {
-						for {
-							logger.Info("Collecting DBaaS metrics")
-							metrics, err := d.GetMetrics(c.Context)
-							if err != nil {
-								logger.Error(err, "cannot execute dbaas collector")
-								wg.Done()
-							}
-
-							if len(metrics) == 0 {
-								logger.Info("No data to export to odoo", "time", time.Now())
-								time.Sleep(time.Minute * time.Duration(collectInterval))
-								continue
-							}
-
-							logger.Info("Exporting data to Odoo", "time", time.Now())
-							err = odooClient.SendData(metrics)
-							if err != nil {
-								logger.Error(err, "cannot export metrics")
-							}
-							time.Sleep(time.Minute * time.Duration(collectInterval))
-						}
-					}()
+						return err
+					}
+					d, err := newDBaaS(c)
+					if err != nil {
+						return err
+					}
 
-					wg.Wait()
-					os.Exit(1)
-					return nil
+					return orphanOpts.run(c.Context, once.enabled, "exoscale", orphanedResources, d.FindOrphans, o.FindOrphans)
 				},
 			},
 		},
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
+	"github.com/vshn/billing-collector-cloudservices/pkg/leaderelection"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElectionOptions holds the flags of the leader election, which lets collectors run with multiple replicas
+type leaderElectionOptions struct {
+	enabled       bool
+	sharedState   bool
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+func (o *leaderElectionOptions) flags() []cli.Flag {
+	hostname, _ := os.Hostname()
+	return []cli.Flag{
+		&cli.BoolFlag{Name: "leader-elect", Usage: "Only collect and send billing records while holding a Lease, so multiple replicas can run safely",
+			EnvVars: []string{"LEADER_ELECT"}, Destination: &o.enabled},
+		&cli.BoolFlag{Name: "leader-election-shared-state", Usage: "Confirm that the outbox, ledger and checkpoints are on a ReadWriteMany volume shared by all replicas. Required for leader election",
+			EnvVars: []string{"LEADER_ELECTION_SHARED_STATE"}, Destination: &o.sharedState},
+		&cli.StringFlag{Name: "leader-election-namespace", Usage: "Namespace of the Lease, defaults to the namespace of the service account",
+			EnvVars: []string{"LEADER_ELECTION_NAMESPACE"}, Destination: &o.namespace, DefaultText: defaultTextForOptionalFlags},
+		&cli.StringFlag{Name: "leader-election-id", Usage: "Name of the Lease, defaults to a name derived from the collector",
+			EnvVars: []string{"LEADER_ELECTION_ID"}, Destination: &o.name, DefaultText: defaultTextForOptionalFlags},
+		&cli.StringFlag{Name: "leader-election-identity", Usage: "Identity of this replica in the Lease",
+			EnvVars: []string{"POD_NAME"}, Destination: &o.identity, Value: hostname},
+		&cli.DurationFlag{Name: "leader-election-lease-duration", Usage: "How long standby replicas wait before taking over a Lease which is not renewed anymore",
+			EnvVars: []string{"LEADER_ELECTION_LEASE_DURATION"}, Destination: &o.leaseDuration, Value: 15 * time.Second},
+		&cli.DurationFlag{Name: "leader-election-renew-deadline", Usage: "How long the leader tries to renew the Lease before it stops collecting",
+			EnvVars: []string{"LEADER_ELECTION_RENEW_DEADLINE"}, Destination: &o.renewDeadline, Value: 10 * time.Second},
+		&cli.DurationFlag{Name: "leader-election-retry-period", Usage: "How often the Lease is renewed or tried to be acquired",
+			EnvVars: []string{"LEADER_ELECTION_RETRY_PERIOD"}, Destination: &o.retryPeriod, Value: 2 * time.Second},
+	}
+}
+
+// run runs fn right away if leader election is disabled, otherwise only while holding the Lease.
+// The Lease is managed in the cluster of the kubeconfig, or the one the collector runs in if it is empty.
+// The replicas need to share the outbox, ledger and checkpoints, otherwise a new leader bills again what the previous one delivered.
+func (o leaderElectionOptions) run(ctx context.Context, kubeconfig, defaultName string, fn func(ctx context.Context) error) error {
+	if !o.enabled {
+		return fn(ctx)
+	}
+	if !o.sharedState {
+		return errors.New("leader election requires the outbox, ledger and checkpoints on a volume shared by all replicas, confirm this with the leader-election-shared-state flag or run a single replica without leader election")
+	}
+
+	k8sClient, err := kubernetes.NewClient(kubeconfig, "", "")
+	if err != nil {
+		return fmt.Errorf("k8s client: %w", err)
+	}
+
+	namespace := o.namespace
+	if namespace == "" {
+		raw, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return fmt.Errorf("leader election namespace not set and not running in a pod: %w", err)
+		}
+		namespace = strings.TrimSpace(string(raw))
+	}
+	name := o.name
+	if name == "" {
+		name = defaultName
+	}
+
+	elector, err := leaderelection.New(k8sClient, leaderelection.Config{
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      o.identity,
+		LeaseDuration: o.leaseDuration,
+		RenewDeadline: o.renewDeadline,
+		RetryPeriod:   o.retryPeriod,
+	})
+	if err != nil {
+		return fmt.Errorf("leader election: %w", err)
+	}
+	return elector.Run(ctx, fn)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+)
+
+// onceOptions holds the flags of the run-once mode, which is meant for running the collectors as CronJobs
+type onceOptions struct {
+	enabled bool
+}
+
+func (o *onceOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{Name: "once", Usage: "Collect and send the billing records of a single period, then exit with a non-zero code on failure",
+			EnvVars: []string{"ONCE"}, Destination: &o.enabled},
+	}
+}
+
+// collectAndSend collects and delivers the billing records of the given period.
+// Pending batches in the outbox are delivered as well, so a failed run is completed by the next one.
+// Collectors may return records together with an error if only part of the usage could be collected.
+// These records are sent, but the error is returned so the period is collected again by the next run.
+func collectAndSend(ctx context.Context, period time.Time, collect func(context.Context, time.Time) ([]odoo.OdooMeteredBillingRecord, error), delivery *recordDelivery) error {
+	logger := log.Logger(ctx)
+
+	logger.Info("Collecting billing records", "period", period)
+	records, collectErr := collect(ctx, period)
+	if collectErr != nil {
+		if len(records) == 0 {
+			return fmt.Errorf("collect: %w", collectErr)
+		}
+		logger.Error(collectErr, "Collected billing records partially, sending them anyway", "period", period, "records", len(records))
+		collectErr = fmt.Errorf("collect partially: %w", collectErr)
+	}
+
+	if len(records) == 0 {
+		logger.Info("No data to export", "period", period)
+		if err := delivery.flush(ctx); err != nil {
+			return fmt.Errorf("deliver pending records: %w", err)
+		}
+		return nil
+	}
+
+	if err := delivery.sendRecords(ctx, records); err != nil {
+		return errors.Join(collectErr, fmt.Errorf("send: %w", err))
+	}
+	logger.Info("Billing records sent", "period", period, "records", len(records))
+	return collectErr
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/orphans"
+)
+
+// orphanOptions holds the flags of the orphan report, which lists provider resources without matching object in the cluster
+type orphanOptions struct {
+	reportFile string
+	schedule   scheduleOptions
+}
+
+func (o *orphanOptions) flags() []cli.Flag {
+	return concatFlags([]cli.Flag{
+		&cli.StringFlag{Name: "orphan-report-file", Usage: "File the JSON orphan report is written to, it is printed to stdout if not set",
+			EnvVars: []string{"ORPHAN_REPORT_FILE"}, Destination: &o.reportFile, DefaultText: defaultTextForOptionalFlags},
+	}, o.schedule.flags("0 * * * *"))
+}
+
+// orphanCheck returns the checked resource kinds and the orphaned resources of a provider service
+type orphanCheck func(ctx context.Context) (kinds []string, orphaned []orphans.Resource, err error)
+
+// run reports the orphans once if once is set, otherwise right away and then at every activation of the schedule
+func (o orphanOptions) run(ctx context.Context, once bool, provider string, gauge *prometheus.GaugeVec, checks ...orphanCheck) error {
+	if once {
+		return o.report(ctx, provider, gauge, checks...)
+	}
+
+	s, err := o.schedule.newScheduler()
+	if err != nil {
+		return fmt.Errorf("scheduler: %w", err)
+	}
+	if err := o.report(ctx, provider, gauge, checks...); err != nil {
+		log.Logger(ctx).Error(err, "cannot report orphans")
+	}
+	return s.Run(ctx, func(ctx context.Context, _ time.Time) error {
+		return o.report(ctx, provider, gauge, checks...)
+	})
+}
+
+// report runs the checks, updates the gauges and writes the JSON report.
+// Failed checks are listed in the report, the results of the other checks are reported anyway.
+func (o orphanOptions) report(ctx context.Context, provider string, gauge *prometheus.GaugeVec, checks ...orphanCheck) error {
+	r := orphans.Report{
+		Provider:    provider,
+		GeneratedAt: time.Now().UTC(),
+		Orphans:     []orphans.Resource{},
+	}
+
+	var errs []error
+	for _, check := range checks {
+		kinds, orphaned, err := check(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			r.Errors = append(r.Errors, err.Error())
+			if orphaned == nil {
+				// keep the last known gauge values of the kinds which could not be checked
+				continue
+			}
+		}
+		r.Kinds = append(r.Kinds, kinds...)
+		r.Orphans = append(r.Orphans, orphaned...)
+	}
+	r.Publish(gauge)
+
+	if err := o.write(r); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (o orphanOptions) write(r orphans.Report) error {
+	if o.reportFile == "" {
+		return r.WriteJSON(os.Stdout)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := r.WriteJSON(buf); err != nil {
+		return fmt.Errorf("cannot encode orphan report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.reportFile), 0o700); err != nil {
+		return fmt.Errorf("cannot create orphan report directory: %w", err)
+	}
+	tmp := o.reportFile + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("cannot write orphan report: %w", err)
+	}
+	return os.Rename(tmp, o.reportFile)
+}
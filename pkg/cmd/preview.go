@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+)
+
+// previewOptions holds the flags of the preview mode, which prints the billing records instead of sending them
+type previewOptions struct {
+	enabled bool
+	format  string
+}
+
+func (o *previewOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{Name: "preview", Usage: "Collect the billing records once, print them and exit without sending anything",
+			EnvVars: []string{"PREVIEW"}, Destination: &o.enabled},
+		&cli.StringFlag{Name: "preview-format", Usage: fmt.Sprintf("Output format of the preview (values: [%s, %s, %s])", odoo.FormatTable, odoo.FormatJSON, odoo.FormatCSV),
+			EnvVars: []string{"PREVIEW_FORMAT"}, Destination: &o.format, Value: odoo.FormatTable,
+			Action: func(c *cli.Context, s string) error {
+				if s != odoo.FormatTable && s != odoo.FormatJSON && s != odoo.FormatCSV {
+					return fmt.Errorf("invalid preview format %q, needs to be one of %s, %s or %s", s, odoo.FormatTable, odoo.FormatJSON, odoo.FormatCSV)
+				}
+				return nil
+			}},
+	}
+}
+
+// print writes the records to stdout in the selected format
+func (o previewOptions) print(records []odoo.OdooMeteredBillingRecord) error {
+	return odoo.WriteRecords(os.Stdout, o.format, records)
+}
+
+// concatFlags joins several lists of flags
+func concatFlags(lists ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, l := range lists {
+		flags = append(flags, l...)
+	}
+	return flags
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+)
+
+// scheduleOptions holds the flags which define when a collector runs
+type scheduleOptions struct {
+	expr     string
+	timezone string
+}
+
+func (o *scheduleOptions) flags(defaultSchedule string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "schedule", Usage: "Cron expression (minute hour day-of-month month day-of-week) defining when to collect the metrics",
+			EnvVars: []string{"SCHEDULE"}, Destination: &o.expr, Value: defaultSchedule},
+		&cli.StringFlag{Name: "timezone", Usage: "Timezone the schedule and the billing windows are aligned to",
+			EnvVars: []string{"TIMEZONE"}, Destination: &o.timezone, Value: scheduler.DefaultTimezone},
+	}
+}
+
+func (o scheduleOptions) newScheduler() (*scheduler.Scheduler, error) {
+	return scheduler.New(o.expr, o.timezone)
+}
+
+// location returns the location of the timezone, which the billing windows are aligned to
+func (o scheduleOptions) location() (*time.Location, error) {
+	location, err := time.LoadLocation(o.timezone)
+	if err != nil {
+		return nil, fmt.Errorf("load location: %w", err)
+	}
+	return location, nil
+}
+
+// legacyScheduleOptions holds the deprecated flags which defined when the collectors ran before --schedule existed
+type legacyScheduleOptions struct {
+	billingHour     int
+	collectInterval int
+}
+
+func (o *legacyScheduleOptions) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{Name: "billing-hour", Usage: "Deprecated, use --schedule instead. Hour of the day the daily collectors run at",
+			EnvVars: []string{"BILLING_HOUR"}, Destination: &o.billingHour, DefaultText: defaultTextForOptionalFlags},
+		&cli.IntFlag{Name: "collect-interval", Usage: "Deprecated, use --schedule instead. Minutes between the runs of exoscale dbaas",
+			EnvVars: []string{"COLLECT_INTERVAL"}, Destination: &o.collectInterval, DefaultText: defaultTextForOptionalFlags},
+	}
+}
+
+// applyLegacy replaces the schedule with the equivalent of the deprecated flags if they are set.
+// Daily collectors ran once a day at the billing hour, exoscale dbaas every collect interval minutes.
+// The deprecated flags cannot be combined with --schedule.
+func (o *scheduleOptions) applyLegacy(c *cli.Context, legacy legacyScheduleOptions, daily bool) error {
+	logger := log.Logger(c.Context)
+
+	billingHourSet, collectIntervalSet := c.IsSet("billing-hour"), c.IsSet("collect-interval")
+	if !billingHourSet && !collectIntervalSet {
+		return nil
+	}
+	if c.IsSet("schedule") {
+		return fmt.Errorf("the deprecated flags billing-hour and collect-interval cannot be combined with schedule")
+	}
+
+	expr := o.expr
+	if daily {
+		if collectIntervalSet {
+			logger.Info("Ignoring deprecated flag collect-interval, every day is billed once", "collectInterval", legacy.collectInterval)
+		}
+		if billingHourSet {
+			if legacy.billingHour < 0 || legacy.billingHour > 23 {
+				return fmt.Errorf("invalid billing-hour value %d, needs to be between 0 and 23", legacy.billingHour)
+			}
+			expr = fmt.Sprintf("0 %d * * *", legacy.billingHour)
+		}
+	} else {
+		if billingHourSet {
+			logger.Info("Ignoring deprecated flag billing-hour, it only applies to daily collectors", "billingHour", legacy.billingHour)
+		}
+		if collectIntervalSet {
+			interval := legacy.collectInterval
+			if interval < 1 || interval > 24 {
+				// same fallback as before the schedule existed
+				interval = 1
+			}
+			expr = fmt.Sprintf("*/%d * * * *", interval)
+		}
+	}
+
+	logger.Info("Deprecated flags billing-hour and collect-interval are set, use schedule instead", "schedule", expr)
+	o.expr = expr
+	return nil
+}
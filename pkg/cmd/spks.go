@@ -14,14 +14,11 @@ import (
 	"github.com/urfave/cli/v2"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+	"github.com/vshn/billing-collector-cloudservices/pkg/spks"
 )
 
 var (
-	prometheusQueryArr = [2]string{
-		"count(max by(name)(max_over_time(crossplane_resource_info{kind=\"compositemariadbinstances\", service_level=\"%s\"}[1d:1d])))",
-		"count(max by(name)(max_over_time(crossplane_resource_info{kind=\"compositeredisinstances\", service_level=\"%s\"}[1d:1d])))",
-	}
-
 	odooURL           string
 	odooOauthTokenURL string
 	odooClientID      string
@@ -31,24 +28,32 @@ var (
 	unitID            string
 	environment       string
 	serviceSLA        string
+	queryCatalog      string
 	days              int
+	deliveryOpts      deliveryOptions
+	preview           previewOptions
+	backfillOpts      backfillOptions
+	once              onceOptions
+	checkpointOpts    checkpointOptions
+	leaderElection    leaderElectionOptions
+	schedule          scheduleOptions
 )
 
-func SpksCMD(allMetrics map[string]map[string]prometheus.Counter, ctx context.Context) *cli.Command {
+func SpksCMD(allMetrics map[string]map[string]prometheus.Counter) *cli.Command {
 
 	return &cli.Command{
 		Name:   "spks",
 		Usage:  "Collect metrics from spks.",
 		Before: addCommandName,
-		Flags: []cli.Flag{
+		Flags: concatFlags([]cli.Flag{
 			&cli.StringFlag{Name: "odoo-url", Usage: "URL of the Odoo Metered Billing API",
 				EnvVars: []string{"ODOO_URL"}, Destination: &odooURL, Value: "https://preprod.central.vshn.ch/api/v2/product_usage_report_POST"},
 			&cli.StringFlag{Name: "odoo-oauth-token-url", Usage: "Oauth Token URL to authenticate with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_TOKEN_URL"}, Destination: &odooOauthTokenURL, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-id", Usage: "Client ID of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientID, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_ID"}, Destination: &odooClientID, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "odoo-oauth-client-secret", Usage: "Client secret of the oauth client to interact with Odoo metered billing API",
-				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: true, DefaultText: defaultTextForRequiredFlags},
+				EnvVars: []string{"ODOO_OAUTH_CLIENT_SECRET"}, Destination: &odooClientSecret, Required: false, DefaultText: defaultTextForOptionalFlags},
 			&cli.StringFlag{Name: "sales-order", Usage: "Sales order to report billing data to",
 				EnvVars: []string{"SALES_ORDER"}, Destination: &salesOrder, Required: false, DefaultText: defaultTextForOptionalFlags, Value: "S10121"},
 			&cli.StringFlag{Name: "prometheus-url", Usage: "URL of the Prometheus API",
@@ -59,126 +64,180 @@ func SpksCMD(allMetrics map[string]map[string]prometheus.Counter, ctx context.Co
 				EnvVars: []string{"ENVIRONMENT"}, Destination: &environment, Required: true, DefaultText: defaultTextForRequiredFlags},
 			&cli.StringFlag{Name: "service-sla", Usage: "The sla of the instances on the cluster (\"standard\" or \"premium\")",
 				EnvVars: []string{"SERVICE_SLA"}, Destination: &serviceSLA, Required: false, DefaultText: defaultTextForOptionalFlags, Value: "standard"},
-			&cli.IntFlag{Name: "days", Usage: "Days of metrics to fetch since today, set to 0 to get current metrics",
+			&cli.StringFlag{Name: "query-catalog", Usage: "YAML or JSON file with the billed SPKS services, the built-in catalog of MariaDB and Redis is used if not set",
+				EnvVars: []string{"QUERY_CATALOG"}, Destination: &queryCatalog, Required: false, DefaultText: defaultTextForOptionalFlags},
+			&cli.IntFlag{Name: "days", Usage: "Days before yesterday to bill in preview and run-once mode, missed days are caught up automatically otherwise",
 				EnvVars: []string{"DAYS"}, Destination: &days, Value: 0, Required: false, DefaultText: defaultTextForOptionalFlags},
-		},
+		}, deliveryOpts.flags(), checkpointOpts.flags(), preview.flags(), once.flags(), schedule.flags("0 6 * * *"), leaderElection.flags()),
 		Action: func(c *cli.Context) error {
-			ctxx, cancel := context.WithCancel(ctx)
-			defer cancel()
 			logger := log.Logger(c.Context)
 			logger.Info("starting spks data collector")
 
-			ticker := time.NewTicker(24 * time.Hour)
+			entries, err := spksEntries()
+			if err != nil {
+				return err
+			}
 
-			daysChannel := make(chan int, 1)
-			if days != 0 {
-				daysChannel <- days
-			} else {
-				runSPKSBilling(logger, allMetrics, c.Context)
+			collect := func(ctx context.Context, day time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+				return collectSPKSBilling(ctx, logger, allMetrics, entries, day)
 			}
 
-			for {
-				select {
-				case <-ctxx.Done():
-					logger.Info("Received Context cancellation, exiting...")
-					return nil
-				case <-ticker.C:
-					// this runs every 24 hours after program start
-					runSPKSBilling(logger, allMetrics, c.Context)
-				case <-daysChannel:
-					runSPKSBilling(logger, allMetrics, c.Context)
-					if days > 0 {
-						days--
-						daysChannel <- days
-					}
+			location, err := schedule.location()
+			if err != nil {
+				return err
+			}
+
+			if preview.enabled {
+				billingRecords, err := collectSPKSBilling(c.Context, logger, allMetrics, entries, spksBillingDay().In(location))
+				if err != nil {
+					return fmt.Errorf("error getting database counts: %w", err)
 				}
+				return preview.print(billingRecords)
+			}
+
+			delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientID, odooClientSecret}, allMetrics["odooMetrics"], logger)
+			if err != nil {
+				return err
+			}
+
+			cp, err := checkpointOpts.newCheckpoint("spks", scheduler.Daily, true, allMetrics["collectorMetrics"])
+			if err != nil {
+				return err
 			}
+
+			s, err := schedule.newScheduler()
+			if err != nil {
+				return fmt.Errorf("scheduler: %w", err)
+			}
+
+			if once.enabled {
+				return cp.run(c.Context, spksBillingDay().In(location), collect, delivery)
+			}
+
+			return leaderElection.run(c.Context, "", "billing-collector-spks", func(ctx context.Context) error {
+				return runScheduled(ctx, s, cp, scheduler.Daily.Previous, collect, delivery)
+			})
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:   "backfill",
+				Usage:  "Send the SPKS billing records of every day in a date range",
+				Before: addCommandName,
+				Flags:  backfillOpts.flags(),
+				Action: func(c *cli.Context) error {
+					logger := log.Logger(c.Context)
+
+					location, err := schedule.location()
+					if err != nil {
+						return err
+					}
+					periods, err := backfillOpts.periods(scheduler.Daily, location)
+					if err != nil {
+						return err
+					}
+
+					entries, err := spksEntries()
+					if err != nil {
+						return err
+					}
+
+					delivery, err := newDelivery(c.Context, deliveryOpts, odooConfig{odooURL, odooOauthTokenURL, odooClientID, odooClientSecret}, allMetrics["odooMetrics"], logger)
+					if err != nil {
+						return err
+					}
+
+					return backfill(c.Context, periods, func(ctx context.Context, day time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+						return collectSPKSBilling(ctx, logger, allMetrics, entries, day)
+					}, delivery)
+				},
+			},
 		},
 	}
 }
 
-func runSPKSBilling(logger logr.Logger, allMetrics map[string]map[string]prometheus.Counter, c context.Context) {
-	// var startYesterdayAbsolute time.Time
-	location, err := time.LoadLocation("Europe/Zurich")
+// spksBillingDay returns the day to bill, which is yesterday if days is 0
+func spksBillingDay() time.Time {
+	return time.Now().AddDate(0, 0, -days-1)
+}
+
+// spksEntries loads the query catalog and renders it for the service SLA and environment
+func spksEntries() ([]spks.Entry, error) {
+	catalog, err := spks.LoadCatalog(queryCatalog)
 	if err != nil {
-		allMetrics["odooMetrics"]["odooFailed"].Inc()
+		return nil, err
 	}
-	now := time.Now().In(location)
+	return catalog.Render(spks.Params{ServiceSLA: serviceSLA, Environment: environment}, unitID)
+}
+
+// collectSPKSBilling creates the billing records of the catalog entries for the given day.
+// The Prometheus queries are cancelled together with ctx, e.g. on shutdown or when the leadership is lost.
+func collectSPKSBilling(ctx context.Context, logger logr.Logger, allMetrics map[string]map[string]prometheus.Counter, entries []spks.Entry, billingDay time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	// the billing day is in the location of the schedule, which the days are aligned to
+	location := billingDay.Location()
+	day := billingDay
 	// this variable is necessary to query Prometheus, with timerange [1d:1d] it returns data from 1 day up to midnight
-	startOfToday := time.Date(now.Year(), now.Month(), now.Day()-days, 0, 0, 0, 0, location)
-	startYesterdayAbsolute := time.Date(now.Year(), now.Month(), now.Day()-days-1, 0, 0, 0, 0, location).In(time.UTC)
+	startOfToday := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, location)
+	startYesterdayAbsolute := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location).In(time.UTC)
 
 	endYesterdayAbsolute := startYesterdayAbsolute.Add(24 * time.Hour)
 
 	logger.Info("Running SPKS billing with such timeranges: ", "startOfToday", startOfToday, "startYesterdayAbsolute", startYesterdayAbsolute.Local(), "endYesterdayAbsolute", endYesterdayAbsolute.Local())
 
-	odooClient := odoo.NewOdooAPIClient(c, odooURL, odooOauthTokenURL, odooClientID, odooClientSecret, logger, allMetrics["odooMetrics"])
-
-	mariadb, redis, err := getDatabasesCounts(logger, startOfToday, allMetrics)
+	counts, err := getInstanceCounts(ctx, logger, startOfToday, entries, allMetrics)
 	if err != nil {
-		logger.Error(err, "Error getting database counts")
+		return nil, err
 	}
 
-	billingRecords := generateBillingRecords(startYesterdayAbsolute, endYesterdayAbsolute, mariadb, redis)
-
-	err = odooClient.SendData(billingRecords)
-	if err != nil {
-		logger.Error(err, "Error sending data to Odoo API")
-	}
+	return generateBillingRecords(startYesterdayAbsolute, endYesterdayAbsolute, entries, counts), nil
 }
 
-func generateBillingRecords(startYesterdayAbsolute time.Time, endYesterdayAbsolute time.Time, mariadb int, redis int) []odoo.OdooMeteredBillingRecord {
+// generateBillingRecords creates a record per catalog entry with the instance count of the entry at the same index
+func generateBillingRecords(startYesterdayAbsolute time.Time, endYesterdayAbsolute time.Time, entries []spks.Entry, counts []int) []odoo.OdooMeteredBillingRecord {
 	timerange := odoo.TimeRange{
 		From: startYesterdayAbsolute,
 		To:   endYesterdayAbsolute,
 	}
 
-	billingRecords := []odoo.OdooMeteredBillingRecord{
-		{
-			ProductID:     "appcat-spks-mariadb-" + serviceSLA,
-			InstanceID:    "mariadb-" + environment,
+	billingRecords := make([]odoo.OdooMeteredBillingRecord, 0, len(entries))
+	for i, entry := range entries {
+		billingRecords = append(billingRecords, odoo.OdooMeteredBillingRecord{
+			ProductID:     entry.ProductID,
+			InstanceID:    entry.InstanceID,
 			SalesOrder:    salesOrder,
-			UnitID:        unitID,
-			ConsumedUnits: float64(mariadb),
+			UnitID:        entry.Unit,
+			ConsumedUnits: float64(counts[i]),
 			TimeRange:     timerange,
-		},
-		{
-			ProductID:     "appcat-spks-redis-" + serviceSLA,
-			InstanceID:    "redis-" + environment,
-			SalesOrder:    salesOrder,
-			UnitID:        unitID,
-			ConsumedUnits: float64(redis),
-			TimeRange:     timerange,
-		},
+		})
 	}
 
 	return billingRecords
 }
 
-func getDatabasesCounts(logger logr.Logger, startOfToday time.Time, allMetrics map[string]map[string]prometheus.Counter) (int, int, error) {
+// getInstanceCounts runs the query of every catalog entry and returns the counts in the order of the entries
+func getInstanceCounts(ctx context.Context, logger logr.Logger, startOfToday time.Time, entries []spks.Entry, allMetrics map[string]map[string]prometheus.Counter) ([]int, error) {
 
 	client, err := api.NewClient(api.Config{
 		Address: prometheusURL,
 	})
 	if err != nil {
-		logger.Error(err, "Error creating Prometheus client")
+		allMetrics["collectorMetrics"]["setupFailed"].Inc()
+		return nil, fmt.Errorf("prometheus client: %w", err)
 	}
 
 	v1api := v1.NewAPI(client)
-	ctxx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctxx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	mariadb, err := QueryPrometheus(ctxx, v1api, fmt.Sprintf(prometheusQueryArr[0], serviceSLA), logger, startOfToday, allMetrics["providerMetrics"])
-	if err != nil {
-		return -1, -1, err
-	}
-
-	redis, err := QueryPrometheus(ctxx, v1api, fmt.Sprintf(prometheusQueryArr[1], serviceSLA), logger, startOfToday, allMetrics["providerMetrics"])
-	if err != nil {
-		return -1, -1, err
+	counts := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		count, err := QueryPrometheus(ctxx, v1api, entry.Query, logger, startOfToday, allMetrics["providerMetrics"])
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", entry.Name, err)
+		}
+		counts = append(counts, count)
 	}
 
-	return mariadb, redis, nil
+	return counts, nil
 }
 
 func QueryPrometheus(ctx context.Context, v1api v1.API, query string, logger logr.Logger, absoluteBeginningTime time.Time, providerMetrics map[string]prometheus.Counter) (int, error) {
@@ -202,7 +261,8 @@ func QueryPrometheus(ctx context.Context, v1api v1.API, query string, logger log
 			return 0, nil
 		}
 	default:
-		logger.Error(err, "result type is not Vector: ", "result", result)
+		err := fmt.Errorf("unexpected result type %s", result.Type())
+		logger.Error(err, "Result of Prometheus query is not a vector", "result", result)
 		providerMetrics["providerFailed"].Inc()
 		return -1, err
 
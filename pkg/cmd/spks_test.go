@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryAPI answers every query with the same result, the other methods of v1.API are not used
+type queryAPI struct {
+	v1.API
+	result model.Value
+}
+
+func (a queryAPI) Query(_ context.Context, _ string, _ time.Time, _ ...v1.Option) (model.Value, v1.Warnings, error) {
+	return a.result, nil, nil
+}
+
+func TestQueryPrometheus(t *testing.T) {
+	tests := map[string]struct {
+		result        model.Value
+		expectedCount int
+		expectedErr   bool
+	}{
+		"given a vector with one sample, we should get its value": {
+			result:        model.Vector{{Value: 3}},
+			expectedCount: 3,
+		},
+		"given an empty vector, we should get 0": {
+			result:        model.Vector{},
+			expectedCount: 0,
+		},
+		"given a scalar, we should get an error": {
+			result:      &model.Scalar{Value: 3},
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			providerMetrics := map[string]prometheus.Counter{
+				"providerFailed":    prometheus.NewCounter(prometheus.CounterOpts{Name: "failed"}),
+				"providerSucceeded": prometheus.NewCounter(prometheus.CounterOpts{Name: "succeeded"}),
+			}
+			count, err := QueryPrometheus(context.Background(), queryAPI{result: tc.result}, "query", logr.Discard(), time.Now(), providerMetrics)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCount, count)
+		})
+	}
+}
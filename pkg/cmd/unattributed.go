@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+)
+
+// unattributedOptions holds the flags of the policy for usage which cannot be attributed to an organization
+type unattributedOptions struct {
+	action       string
+	organization string
+	salesOrder   string
+}
+
+func (o *unattributedOptions) flags(defaultAction unattributed.Action, defaultOrganization string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "unattributed-policy", Usage: fmt.Sprintf("What to do with usage which cannot be attributed to an organization (values: [%s, %s, %s])", unattributed.BillOrganization, unattributed.Skip, unattributed.BillSalesOrder),
+			EnvVars: []string{"UNATTRIBUTED_POLICY"}, Destination: &o.action, Value: string(defaultAction)},
+		&cli.StringFlag{Name: "unattributed-organization", Usage: fmt.Sprintf("Organization to bill unattributed usage to with policy %s", unattributed.BillOrganization),
+			EnvVars: []string{"UNATTRIBUTED_ORGANIZATION"}, Destination: &o.organization, Value: defaultOrganization, DefaultText: defaultTextForOptionalFlags},
+		&cli.StringFlag{Name: "unattributed-sales-order", Usage: fmt.Sprintf("Internal sales order to bill unattributed usage to with policy %s", unattributed.BillSalesOrder),
+			EnvVars: []string{"UNATTRIBUTED_SALES_ORDER"}, Destination: &o.salesOrder, DefaultText: defaultTextForOptionalFlags},
+	}
+}
+
+// policy returns the validated policy of a provider, its decisions are counted in the metric
+func (o unattributedOptions) policy(provider string, metric *prometheus.CounterVec) (unattributed.Policy, error) {
+	p := unattributed.Policy{
+		Action:       unattributed.Action(o.action),
+		Organization: o.organization,
+		SalesOrder:   o.salesOrder,
+		Provider:     provider,
+		Metric:       metric,
+	}
+	if err := p.Validate(); err != nil {
+		return unattributed.Policy{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,205 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const productIdBlockStorage = "appcat-exoscale-blockstorage"
+
+// ComputeInstance is an Exoscale compute instance in a zone
+type ComputeInstance struct {
+	egoscale.ListInstancesResponseInstances
+	Zone string
+}
+
+// BlockVolume is an Exoscale block storage volume in a zone
+type BlockVolume struct {
+	egoscale.BlockStorageVolume
+	Zone string
+}
+
+// Compute gathers compute instances and block storage volumes labelled with the cluster id from Exoscale
+type Compute struct {
+	labelAttribution
+	exoscaleClient *egoscale.Client
+	k8sClient      k8s.Client
+	uomMapping     map[string]string
+	zones          ZoneFetcher
+}
+
+// NewCompute creates a Compute with the initial setup
+func NewCompute(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, zones ZoneFetcher) (*Compute, error) {
+	return &Compute{
+		labelAttribution: labelAttribution{
+			controlApiClient: controlApiClient,
+			salesOrder:       salesOrder,
+			clusterId:        clusterId,
+			cloudZone:        cloudZone,
+		},
+		exoscaleClient: exoscaleClient,
+		k8sClient:      k8sClient,
+		uomMapping:     uomMapping,
+		zones:          zones,
+	}, nil
+}
+
+// GetMetrics returns the billing records of the given hour.
+// Exoscale only reports the current instances and volumes, so the records of past hours are based on them as well.
+// If some zones could not be fetched, the records of the others are returned together with the error.
+func (c *Compute) GetMetrics(ctx context.Context, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	logger := log.Logger(ctx)
+
+	namespaces, err := fetchNamespaces(ctx, c.k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Fetching instance types from Exoscale")
+	types, err := c.exoscaleClient.ListInstanceTypes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list instance types: %w", err)
+	}
+	instanceTypes := make(map[string]string, len(types.InstanceTypes))
+	for _, t := range types.InstanceTypes {
+		instanceTypes[string(t.ID)] = fmt.Sprintf("%s-%s", t.Family, t.Size)
+	}
+
+	instances, instancesErr := c.fetchInstances(ctx)
+	volumes, volumesErr := c.fetchVolumes(ctx)
+	zoneErr := &ZoneError{}
+	for _, err := range []error{instancesErr, volumesErr} {
+		if err != nil && !errors.As(err, &zoneErr) {
+			return nil, err
+		}
+	}
+
+	records, err := c.AggregateCompute(ctx, instances, volumes, instanceTypes, namespaces, billingHour)
+	if err != nil {
+		return nil, err
+	}
+	return records, errors.Join(instancesErr, volumesErr)
+}
+
+func (c *Compute) fetchInstances(ctx context.Context) ([]ComputeInstance, error) {
+	log.Logger(ctx).Info("Fetching compute instances from Exoscale")
+
+	instances, err := fetchZones(ctx, c.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]ComputeInstance, error) {
+		resp, err := c.exoscaleClient.WithEndpoint(endpoint).ListInstances(ctx)
+		if err != nil {
+			return nil, err
+		}
+		zone := endpointZone(c.zones.Zones, endpoint)
+		instances := make([]ComputeInstance, 0, len(resp.Instances))
+		for _, instance := range resp.Instances {
+			instances = append(instances, ComputeInstance{ListInstancesResponseInstances: instance, Zone: zone})
+		}
+		return instances, nil
+	})
+	if err != nil {
+		return instances, fmt.Errorf("fetchInstances: %w", err)
+	}
+	return instances, nil
+}
+
+func (c *Compute) fetchVolumes(ctx context.Context) ([]BlockVolume, error) {
+	log.Logger(ctx).Info("Fetching block storage volumes from Exoscale")
+
+	volumes, err := fetchZones(ctx, c.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]BlockVolume, error) {
+		resp, err := c.exoscaleClient.WithEndpoint(endpoint).ListBlockStorageVolumes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		zone := endpointZone(c.zones.Zones, endpoint)
+		volumes := make([]BlockVolume, 0, len(resp.BlockStorageVolumes))
+		for _, volume := range resp.BlockStorageVolumes {
+			volumes = append(volumes, BlockVolume{BlockStorageVolume: volume, Zone: zone})
+		}
+		return volumes, nil
+	})
+	if err != nil {
+		return volumes, fmt.Errorf("fetchVolumes: %w", err)
+	}
+	return volumes, nil
+}
+
+// AggregateCompute creates the billing records of the running instances and the block volumes labelled with the cluster id for the given billing hour.
+// Instances are billed per hour and instance type, volumes per GiB and day, prorated to the hour.
+func (c *Compute) AggregateCompute(ctx context.Context, instances []ComputeInstance, volumes []BlockVolume, instanceTypes map[string]string, namespaces map[string]string, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	logger := log.Logger(ctx)
+	logger.Info("Aggregating compute instances and block volumes by namespace")
+
+	timeRange := hourTimeRange(billingHour)
+
+	records := make([]odoo.OdooMeteredBillingRecord, 0)
+	for _, instance := range instances {
+		if !c.inCluster(instance.Labels) || instance.State != egoscale.InstanceStateRunning {
+			continue
+		}
+		if instance.InstanceType == nil {
+			logger.Info("Instance type is missing in instance, skipping...", "instance", instance.Name)
+			continue
+		}
+		instanceType, ok := instanceTypes[string(instance.InstanceType.ID)]
+		if !ok {
+			logger.Info("Instance type not found, skipping...", "instance", instance.Name, "type", instance.InstanceType.ID)
+			continue
+		}
+
+		itemGroup, salesOrder, ok := c.attribute(ctx, instance.Labels, namespaces)
+		if !ok {
+			continue
+		}
+		records = append(records, odoo.OdooMeteredBillingRecord{
+			ProductID:            fmt.Sprintf("%s-compute-%s", productIdPrefix, instanceType),
+			InstanceID:           fmt.Sprintf("%s/%s", instance.Zone, instance.ID),
+			ItemDescription:      instance.Name,
+			ItemGroupDescription: itemGroup,
+			SalesOrder:           salesOrder,
+			UnitID:               c.uomMapping[odoo.InstanceHour],
+			ConsumedUnits:        1,
+			TimeRange:            timeRange,
+		})
+	}
+
+	for _, volume := range volumes {
+		if !c.inCluster(volume.Labels) {
+			continue
+		}
+
+		itemGroup, salesOrder, ok := c.attribute(ctx, volume.Labels, namespaces)
+		if !ok {
+			continue
+		}
+		records = append(records, odoo.OdooMeteredBillingRecord{
+			ProductID:            productIdBlockStorage,
+			InstanceID:           fmt.Sprintf("%s/%s", volume.Zone, volume.ID),
+			ItemDescription:      volume.Name,
+			ItemGroupDescription: itemGroup,
+			SalesOrder:           salesOrder,
+			UnitID:               c.uomMapping[odoo.GBDay],
+			// the volume size is in GiB
+			ConsumedUnits: float64(volume.Size) / 24,
+			TimeRange:     timeRange,
+		})
+	}
+
+	return records, nil
+}
+
+func CheckComputeUOMExistence(mapping map[string]string) error {
+	if mapping[odoo.InstanceHour] == "" {
+		return fmt.Errorf("missing UOM mapping %s", odoo.InstanceHour)
+	}
+	if mapping[odoo.GBDay] == "" {
+		return fmt.Errorf("missing UOM mapping %s", odoo.GBDay)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package exoscale
+
+import (
+	"testing"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+)
+
+func TestCompute_AggregateCompute(t *testing.T) {
+	ctx := getTestContext(t)
+
+	location, _ := time.LoadLocation("Europe/Zurich")
+	hour := time.Date(2024, 3, 5, 10, 0, 0, 0, location)
+	timeRange := odoo.TimeRange{
+		From: hour.In(time.UTC),
+		To:   hour.Add(time.Hour).In(time.UTC),
+	}
+
+	instance := func(id, state string, labels egoscale.Labels) ComputeInstance {
+		return ComputeInstance{
+			ListInstancesResponseInstances: egoscale.ListInstancesResponseInstances{
+				ID:           egoscale.UUID(id),
+				Name:         "node-" + id,
+				State:        egoscale.InstanceState(state),
+				Labels:       labels,
+				InstanceType: &egoscale.InstanceType{ID: "type-1"},
+			},
+			Zone: "ch-gva-2",
+		}
+	}
+
+	tests := map[string]struct {
+		instances []ComputeInstance
+		volumes   []BlockVolume
+		expected  []odoo.OdooMeteredBillingRecord
+	}{
+		"given running instances of the cluster, we should get instance hour records": {
+			instances: []ComputeInstance{
+				instance("a", "running", egoscale.Labels{clusterLabel: "c-test1", computeNamespaceLabel: "vshn-xyz"}),
+				instance("b", "running", egoscale.Labels{clusterLabel: "c-test1"}),
+			},
+			expected: []odoo.OdooMeteredBillingRecord{
+				{
+					ProductID:            "appcat-exoscale-compute-standard-medium",
+					InstanceID:           "ch-gva-2/a",
+					ItemDescription:      "node-a",
+					ItemGroupDescription: "APPUiO Managed - Cluster: c-test1 / Namespace: vshn-xyz",
+					SalesOrder:           "1234",
+					UnitID:               "uom-instance-hour",
+					ConsumedUnits:        1,
+					TimeRange:            timeRange,
+				},
+				{
+					ProductID:            "appcat-exoscale-compute-standard-medium",
+					InstanceID:           "ch-gva-2/b",
+					ItemDescription:      "node-b",
+					ItemGroupDescription: "APPUiO Managed - Cluster: c-test1",
+					SalesOrder:           "1234",
+					UnitID:               "uom-instance-hour",
+					ConsumedUnits:        1,
+					TimeRange:            timeRange,
+				},
+			},
+		},
+		"given stopped instances or instances of other clusters, we should not get records": {
+			instances: []ComputeInstance{
+				instance("a", "stopped", egoscale.Labels{clusterLabel: "c-test1"}),
+				instance("b", "running", egoscale.Labels{clusterLabel: "c-other"}),
+				instance("c", "running", nil),
+			},
+			expected: []odoo.OdooMeteredBillingRecord{},
+		},
+		"given volumes of the cluster, we should get GiB day records prorated to the hour": {
+			volumes: []BlockVolume{
+				{
+					BlockStorageVolume: egoscale.BlockStorageVolume{ID: "v", Name: "data", Size: 120, Labels: egoscale.Labels{clusterLabel: "c-test1"}},
+					Zone:               "de-fra-1",
+				},
+				{
+					BlockStorageVolume: egoscale.BlockStorageVolume{ID: "w", Name: "other", Size: 100},
+					Zone:               "de-fra-1",
+				},
+			},
+			expected: []odoo.OdooMeteredBillingRecord{
+				{
+					ProductID:            "appcat-exoscale-blockstorage",
+					InstanceID:           "de-fra-1/v",
+					ItemDescription:      "data",
+					ItemGroupDescription: "APPUiO Managed - Cluster: c-test1",
+					SalesOrder:           "1234",
+					UnitID:               "uom-gb-day",
+					ConsumedUnits:        5,
+					TimeRange:            timeRange,
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, _ := NewCompute(nil, nil, nil, "1234", "c-test1", "", map[string]string{odoo.InstanceHour: "uom-instance-hour", odoo.GBDay: "uom-gb-day"}, ZoneFetcher{})
+			records, err := c.AggregateCompute(ctx, tc.instances, tc.volumes, map[string]string{"type-1": "standard-medium"}, map[string]string{}, hour)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, records)
+		})
+	}
+}
+
+func TestHourTimeRange(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+
+	// the hours are aligned to the timezone of the schedule, not to Europe/Zurich
+	assert.Equal(t, odoo.TimeRange{
+		From: time.Date(2024, 3, 5, 4, 30, 0, 0, time.UTC),
+		To:   time.Date(2024, 3, 5, 5, 30, 0, 0, time.UTC),
+	}, hourTimeRange(time.Date(2024, 3, 5, 10, 15, 0, 0, kolkata)))
+}
@@ -2,7 +2,11 @@ package exoscale
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"strings"
+	"sync"
 	"time"
 
 	egoscale "github.com/exoscale/egoscale/v3"
@@ -10,13 +14,18 @@ import (
 	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
-	"k8s.io/apimachinery/pkg/api/errors"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8s "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-const productIdPrefix = "appcat-exoscale"
+const (
+	productIdPrefix = "appcat-exoscale"
+	// cloudZoneAnnotation is the annotation of the managed resources with the Exoscale zone of the DBaaS service
+	cloudZoneAnnotation = "appcat.vshn.io/cloudzone"
+)
 
 var (
 	groupVersionKinds = map[string]schema.GroupVersionKind{
@@ -56,9 +65,19 @@ var (
 	}
 )
 
+// DBaaSStorage is the storage of a DBaaS service which is billed in addition to its plan, in bytes
+type DBaaSStorage struct {
+	// ExtraDisk is the disk size of the service beyond the disk space of its plan
+	ExtraDisk int64
+	// Backups is the size of the retained backups of the service before compression
+	Backups int64
+}
+
 // Detail a helper structure for intermediate operations
 type Detail struct {
 	Organization, DBName, Namespace, Plan, Zone, Kind string
+	// SalesOrder is set if the unattributed usage policy bills the instance to a dedicated sales order
+	SalesOrder string
 }
 
 // DBaaS provides DBaaS Odoo info and required clients
@@ -69,12 +88,17 @@ type DBaaS struct {
 	salesOrder       string
 	clusterId        string
 	cloudZone        string
-	collectInterval  int
 	uomMapping       map[string]string
+	zones            ZoneFetcher
+	unattributed     unattributed.Policy
+	// billExtraDisk enables the records of the disk beyond the disk of the plan
+	billExtraDisk bool
+	// billBackups enables the records of the backups
+	billBackups bool
 }
 
 // NewDBaaS creates a Service with the initial setup
-func NewDBaaS(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, collectInterval int, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string) (*DBaaS, error) {
+func NewDBaaS(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, zones ZoneFetcher, unattributedPolicy unattributed.Policy, billExtraDisk, billBackups bool) (*DBaaS, error) {
 	return &DBaaS{
 		exoscaleClient:   exoscaleClient,
 		k8sClient:        k8sClient,
@@ -82,23 +106,51 @@ func NewDBaaS(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiC
 		salesOrder:       salesOrder,
 		clusterId:        clusterId,
 		cloudZone:        cloudZone,
-		collectInterval:  collectInterval,
 		uomMapping:       uomMapping,
+		zones:            zones,
+		unattributed:     unattributedPolicy,
+		billExtraDisk:    billExtraDisk,
+		billBackups:      billBackups,
 	}, nil
 }
 
-func (ds *DBaaS) GetMetrics(ctx context.Context) ([]odoo.OdooMeteredBillingRecord, error) {
+// GetMetrics returns the billing records of the given hour.
+// Exoscale only reports the currently running services, so the records of past hours are based on them as well.
+func (ds *DBaaS) GetMetrics(ctx context.Context, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	detail, err := ds.fetchManagedDBaaSAndNamespaces(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("fetchManagedDBaaSAndNamespaces: %w", err)
 	}
 
 	usage, err := ds.fetchDBaaSUsage(ctx)
-	if err != nil {
+	zoneErr := &ZoneError{}
+	if err != nil && !errors.As(err, &zoneErr) {
 		return nil, fmt.Errorf("fetchDBaaSUsage: %w", err)
 	}
 
-	return ds.AggregateDBaaS(ctx, usage, detail)
+	if len(zoneErr.Failed) > 0 {
+		// the usage only contains the services of the zones which answered, they are billed,
+		// the services of failed zones are billed when the hour is retried
+		ds.countUnanswered(ctx, usage, detail, zoneErr)
+	}
+
+	var storage map[string]DBaaSStorage
+	var storageErr error
+	if ds.billExtraDisk || ds.billBackups {
+		storage, storageErr = ds.fetchDBaaSStorage(ctx, usage, detail)
+	}
+
+	records, aggErr := ds.AggregateDBaaS(ctx, usage, detail, storage, billingHour)
+	if aggErr != nil {
+		return nil, aggErr
+	}
+	if err != nil {
+		err = fmt.Errorf("fetchDBaaSUsage: %w", err)
+	}
+	if storageErr != nil {
+		storageErr = fmt.Errorf("fetchDBaaSStorage: %w", storageErr)
+	}
+	return records, errors.Join(err, storageErr)
 }
 
 // fetchManagedDBaaSAndNamespaces fetches instances and namespaces from kubernetes cluster
@@ -111,20 +163,23 @@ func (ds *DBaaS) fetchManagedDBaaSAndNamespaces(ctx context.Context) ([]Detail,
 		return nil, fmt.Errorf("cannot list namespaces: %w", err)
 	}
 
+	report := ds.unattributed.NewReport()
+	defer report.Log(ctx)
+
 	var dbaasDetails []Detail
 	for _, gvk := range groupVersionKinds {
 		metaList := &metav1.PartialObjectMetadataList{}
 		metaList.SetGroupVersionKind(gvk)
 		err := ds.k8sClient.List(ctx, metaList)
 		if err != nil {
-			if errors.IsNotFound(err) {
+			if apierrors.IsNotFound(err) {
 				continue
 			}
 			return nil, fmt.Errorf("cannot list managed resource kind %s from cluster: %w", gvk.Kind, err)
 		}
 
 		for _, item := range metaList.Items {
-			dbaasDetail := findDBaaSDetailInNamespacesMap(ctx, item, gvk, namespaces)
+			dbaasDetail := findDBaaSDetailInNamespacesMap(ctx, report, item, gvk, namespaces)
 			if dbaasDetail == nil {
 				continue
 			}
@@ -135,54 +190,254 @@ func (ds *DBaaS) fetchManagedDBaaSAndNamespaces(ctx context.Context) ([]Detail,
 	return dbaasDetails, nil
 }
 
-func findDBaaSDetailInNamespacesMap(ctx context.Context, resource metav1.PartialObjectMetadata, gvk schema.GroupVersionKind, namespaces map[string]string) *Detail {
+// findDBaaSDetailInNamespacesMap returns the detail of a DBaaS instance with the organization of its namespace.
+// If the instance has no namespace or the namespace is unknown, the unattributed usage policy decides whether and how it is billed.
+func findDBaaSDetailInNamespacesMap(ctx context.Context, report *unattributed.Report, resource metav1.PartialObjectMetadata, gvk schema.GroupVersionKind, namespaces map[string]string) *Detail {
 	logger := log.Logger(ctx).WithValues("dbaas", resource.GetName())
 
-	namespace, exist := resource.GetLabels()[namespaceLabel]
-	if !exist {
-		// cannot get namespace from DBaaS
-		logger.Info("Namespace label is missing in DBaaS, skipping...", "label", namespaceLabel)
-		return nil
+	dbaasDetail := Detail{
+		DBName: resource.GetName(),
+		Kind:   gvk.Kind,
+		Zone:   resource.GetAnnotations()[cloudZoneAnnotation],
 	}
 
+	namespace, exist := resource.GetLabels()[namespaceLabel]
 	organization, ok := namespaces[namespace]
-	if !ok {
-		// cannot find namespace in namespace list
-		logger.Info("Namespace not found in namespace list, skipping...", "namespace", namespace)
-		return nil
-	}
-
-	dbaasDetail := Detail{
-		DBName:       resource.GetName(),
-		Kind:         gvk.Kind,
-		Namespace:    namespace,
-		Organization: organization,
-		Zone:         resource.GetAnnotations()["appcat.vshn.io/cloudzone"],
+	if !exist || !ok {
+		reason := "namespace not found in namespace list"
+		if !exist {
+			reason = fmt.Sprintf("namespace label %s is missing", namespaceLabel)
+		}
+		decision := report.Decide(gvk.Kind, resource.GetName(), reason)
+		if decision.Action == unattributed.Skip {
+			logger.Info("DBaaS could not be attributed, skipping...", "namespace", namespace, "reason", reason)
+			return nil
+		}
+		organization = decision.Organization
+		dbaasDetail.SalesOrder = decision.SalesOrder
 	}
+	dbaasDetail.Namespace = namespace
+	dbaasDetail.Organization = organization
 
 	logger.V(1).Info("Added namespace and organization to DBaaS", "namespace", dbaasDetail.Namespace, "organization", dbaasDetail.Organization)
 	return &dbaasDetail
 }
 
-// fetchDBaaSUsage gets DBaaS service usage from Exoscale
+// countUnanswered logs and counts per kind the instances which are not in the usage of the zones which answered.
+// They are either in one of the failed zones or do not exist on Exoscale, which cannot be told apart until the failed zones answer again.
+func (ds *DBaaS) countUnanswered(ctx context.Context, usage []egoscale.DBAASServiceCommon, dbaasDetails []Detail, zoneErr *ZoneError) {
+	logger := log.Logger(ctx)
+
+	found := make(map[string]bool, len(usage))
+	for _, u := range usage {
+		found[string(u.Name)] = true
+	}
+	failed := make([]string, 0, len(zoneErr.Failed))
+	for zone := range zoneErr.Failed {
+		failed = append(failed, zone)
+	}
+	for _, d := range dbaasDetails {
+		if found[d.DBName] {
+			continue
+		}
+		logger.Info("DBaaS not found in the zones which answered, it may be in a failed zone", "instance", d.DBName, "failedZones", failed)
+		incZoneMetric(ds.zones.Metrics, "providerZoneMissing", strings.TrimSuffix(d.Kind, "List"))
+	}
+}
+
+// fetchDBaaSUsage gets DBaaS service usage from Exoscale.
+// Zones are fetched independently, if some of them fail the services of the others are returned together with a *ZoneError.
 func (ds *DBaaS) fetchDBaaSUsage(ctx context.Context) ([]egoscale.DBAASServiceCommon, error) {
 	logger := log.Logger(ctx)
 	logger.Info("Fetching DBaaS usage from Exoscale")
 
-	var databaseServices []egoscale.DBAASServiceCommon
-	for _, endpoint := range Endpoints {
+	return fetchZones(ctx, ds.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]egoscale.DBAASServiceCommon, error) {
 		databaseServicesByZone, err := ds.exoscaleClient.WithEndpoint(endpoint).ListDBAASServices(ctx)
 		if err != nil {
-			logger.V(1).Error(err, "Cannot get exoscale database services on endpoint", "endpoint", endpoint)
 			return nil, err
 		}
-		databaseServices = append(databaseServices, databaseServicesByZone.DBAASServices...)
+		return databaseServicesByZone.DBAASServices, nil
+	})
+}
+
+// fetchDBaaSStorage gets the disk beyond the plan and the backups of the services with details from Exoscale.
+// The plans are fetched per zone, if some zones fail the extra disk of the services in the others is returned together with a *ZoneError.
+// Services whose backups could not be fetched have no backups in the returned map, the error of their lookup is returned together with the others.
+func (ds *DBaaS) fetchDBaaSStorage(ctx context.Context, usage []egoscale.DBAASServiceCommon, dbaasDetails []Detail) (map[string]DBaaSStorage, error) {
+	billed := make(map[string]bool, len(dbaasDetails))
+	for _, d := range dbaasDetails {
+		billed[d.DBName] = true
+	}
+	services := make([]egoscale.DBAASServiceCommon, 0, len(usage))
+	for _, service := range usage {
+		if billed[string(service.Name)] {
+			services = append(services, service)
+		}
+	}
+
+	storage := map[string]DBaaSStorage{}
+	var errs []error
+	if ds.billExtraDisk {
+		planDiskSpace, err := ds.fetchDBaaSPlanDiskSpace(ctx)
+		errs = append(errs, err)
+		for _, service := range services {
+			if diskSpace, ok := planDiskSpace[planKey(service.Zone, service.Type, service.Plan)]; ok {
+				s := storage[string(service.Name)]
+				s.ExtraDisk = extraDisk(service, diskSpace)
+				storage[string(service.Name)] = s
+			}
+		}
+	}
+	if ds.billBackups {
+		backups, err := ds.fetchDBaaSBackups(ctx, services)
+		errs = append(errs, err)
+		for name, b := range backups {
+			s := storage[name]
+			s.Backups = backupSize(b)
+			storage[name] = s
+		}
+	}
+
+	return storage, errors.Join(errs...)
+}
+
+// fetchDBaaSPlanDiskSpace gets the disk space of the DBaaS plans of every zone, by planKey
+func (ds *DBaaS) fetchDBaaSPlanDiskSpace(ctx context.Context) (map[string]int64, error) {
+	logger := log.Logger(ctx)
+	logger.Info("Fetching DBaaS plans from Exoscale")
+
+	type zoneServiceTypes struct {
+		zone         string
+		serviceTypes []egoscale.DBAASServiceType
+	}
+	zones, err := fetchZones(ctx, ds.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]zoneServiceTypes, error) {
+		serviceTypes, err := ds.exoscaleClient.WithEndpoint(endpoint).ListDBAASServiceTypes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []zoneServiceTypes{{zone: endpointZone(ds.zones.Zones, endpoint), serviceTypes: serviceTypes.DBAASServiceTypes}}, nil
+	})
+	planDiskSpace := map[string]int64{}
+	for _, z := range zones {
+		maps.Copy(planDiskSpace, planDiskSpaces(z.zone, z.serviceTypes))
+	}
+	return planDiskSpace, err
+}
+
+// planDiskSpaces returns the disk space of the plans of the service types of a zone, by planKey
+func planDiskSpaces(zone string, serviceTypes []egoscale.DBAASServiceType) map[string]int64 {
+	planDiskSpace := map[string]int64{}
+	for _, serviceType := range serviceTypes {
+		for _, plan := range serviceType.Plans {
+			planDiskSpace[planKey(zone, serviceType.Name, plan.Name)] = plan.DiskSpace
+		}
+	}
+	return planDiskSpace
+}
+
+func planKey(zone string, serviceType egoscale.DBAASServiceTypeName, plan string) string {
+	return zone + "/" + string(serviceType) + "/" + plan
+}
+
+// extraDisk returns the disk size of a service beyond the disk space of its plan.
+// Exoscale reports both with the same semantics, the size of the data the service can store, so the difference is not scaled by the number of nodes.
+func extraDisk(service egoscale.DBAASServiceCommon, planDiskSpace int64) int64 {
+	return max(service.DiskSize-planDiskSpace, 0)
+}
+
+// fetchDBaaSBackups gets the backups of the services from the zone they run in, by service name.
+// Up to as many services as zones are fetched at the same time.
+func (ds *DBaaS) fetchDBaaSBackups(ctx context.Context, services []egoscale.DBAASServiceCommon) (map[string][]egoscale.DBAASServiceBackup, error) {
+	logger := log.Logger(ctx)
+	logger.Info("Fetching DBaaS backups from Exoscale")
+
+	workers := max(ds.zones.Workers, 1)
+	timeout := ds.zones.Timeout
+	if timeout <= 0 {
+		timeout = DefaultZoneTimeout
+	}
+
+	backups := make(map[string][]egoscale.DBAASServiceBackup, len(services))
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			serviceCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			b, err := serviceBackups(serviceCtx, ds.exoscaleClient.WithEndpoint(zoneEndpoint(service.Zone)), service)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cannot get backups of DBaaS %s/%s: %w", service.Zone, service.Name, err))
+				return
+			}
+			backups[string(service.Name)] = b
+		}()
+	}
+	wg.Wait()
+	return backups, errors.Join(errs...)
+}
+
+// serviceBackups gets the backups of a DBaaS service, which are only listed in the details of its service type
+func serviceBackups(ctx context.Context, client *egoscale.Client, service egoscale.DBAASServiceCommon) ([]egoscale.DBAASServiceBackup, error) {
+	name := string(service.Name)
+
+	switch string(service.Type) {
+	case "pg":
+		s, err := client.GetDBAASServicePG(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return s.Backups, nil
+	case "mysql":
+		s, err := client.GetDBAASServiceMysql(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return s.Backups, nil
+	case "opensearch":
+		s, err := client.GetDBAASServiceOpensearch(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return s.Backups, nil
+	case "redis":
+		s, err := client.GetDBAASServiceRedis(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return s.Backups, nil
+	case "kafka":
+		s, err := client.GetDBAASServiceKafka(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return s.Backups, nil
+	}
+	return nil, nil
+}
+
+// backupSize returns the size of the retained backups of a service.
+// Exoscale reports the size of the data in a backup before compression, which is what is billed.
+func backupSize(backups []egoscale.DBAASServiceBackup) int64 {
+	var size int64
+	for _, backup := range backups {
+		size += backup.DataSize
 	}
-	return databaseServices, nil
+	return size
 }
 
-// AggregateDBaaS aggregates DBaaS services by namespaces and plan
-func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DBAASServiceCommon, dbaasDetails []Detail) ([]odoo.OdooMeteredBillingRecord, error) {
+// AggregateDBaaS aggregates DBaaS services by namespaces and plan for the given billing hour.
+// Disk beyond the plan and backups are billed in separate records per GiB and hour.
+func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DBAASServiceCommon, dbaasDetails []Detail, storage map[string]DBaaSStorage, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	logger := log.Logger(ctx)
 	logger.Info("Aggregating DBaaS instances by namespace and plan")
 
@@ -192,14 +447,7 @@ func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DB
 		dbaasServiceUsageMap[string(usage.Name)] = usage
 	}
 
-	location, err := time.LoadLocation("Europe/Zurich")
-	if err != nil {
-		return nil, fmt.Errorf("load loaction: %w", err)
-	}
-
-	now := time.Now().In(location)
-	billingDateStart := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location()).In(time.UTC)
-	billingDateEnd := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location()).In(time.UTC)
+	timeRange := hourTimeRange(billingHour)
 
 	records := make([]odoo.OdooMeteredBillingRecord, 0)
 	for _, dbaasDetail := range dbaasDetails {
@@ -210,10 +458,17 @@ func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DB
 			logger.V(1).Info("Found exoscale dbaas usage", "instance", dbaasUsage.Name, "instance created", dbaasUsage.CreatedAT)
 
 			itemGroup := fmt.Sprintf("APPUiO Managed - Cluster: %s / Namespace: %s", ds.clusterId, dbaasDetail.Namespace)
-			instanceId := fmt.Sprintf("%s/%s", dbaasDetail.Zone, dbaasDetail.DBName)
+			// the zone reported by Exoscale is authoritative, the annotation of the managed resource may be missing
+			instanceId := fmt.Sprintf("%s/%s", dbaasUsage.Zone, dbaasDetail.DBName)
 			salesOrder := ds.salesOrder
-			if salesOrder == "" {
+			if dbaasDetail.SalesOrder != "" {
+				salesOrder = dbaasDetail.SalesOrder
+			}
+			if ds.salesOrder == "" {
 				itemGroup = fmt.Sprintf("APPUiO Cloud - Zone: %s / Namespace: %s", ds.cloudZone, dbaasDetail.Namespace)
+			}
+			if salesOrder == "" {
+				var err error
 				salesOrder, err = controlAPI.GetSalesOrder(ctx, ds.controlApiClient, dbaasDetail.Organization)
 				if err != nil {
 					logger.Error(err, "Unable to sync DBaaS, cannot get salesOrder", "namespace", dbaasDetail.Namespace)
@@ -229,14 +484,27 @@ func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DB
 				SalesOrder:           salesOrder,
 				UnitID:               ds.uomMapping[odoo.InstanceHour],
 				ConsumedUnits:        1,
-				TimeRange: odoo.TimeRange{
-					From: billingDateStart,
-					To:   billingDateEnd,
-				},
+				TimeRange:            timeRange,
 			}
 
 			records = append(records, o)
 
+			s := storage[dbaasDetail.DBName]
+			if s.ExtraDisk > 0 {
+				disk := o
+				disk.ProductID = productIdPrefix + fmt.Sprintf("-v2-%s-disk", string(dbaasUsage.Type))
+				disk.UnitID = ds.uomMapping[odoo.GBHour]
+				disk.ConsumedUnits = bytesToGiB(s.ExtraDisk)
+				records = append(records, disk)
+			}
+			if s.Backups > 0 {
+				backup := o
+				backup.ProductID = productIdPrefix + fmt.Sprintf("-v2-%s-backup", string(dbaasUsage.Type))
+				backup.UnitID = ds.uomMapping[odoo.GBHour]
+				backup.ConsumedUnits = bytesToGiB(s.Backups)
+				records = append(records, backup)
+			}
+
 		} else {
 			logger.Info("Could not find any DBaaS on exoscale", "instance", dbaasDetail.DBName)
 		}
@@ -245,9 +513,17 @@ func (ds *DBaaS) AggregateDBaaS(ctx context.Context, exoscaleDBaaS []egoscale.DB
 	return records, nil
 }
 
-func CheckDBaaSUOMExistence(mapping map[string]string) error {
+func bytesToGiB(value int64) float64 {
+	return float64(value) / 1024 / 1024 / 1024
+}
+
+// CheckDBaaSUOMExistence checks the UOM mappings of the DBaaS records, GBHour is only needed if extra disk or backups are billed
+func CheckDBaaSUOMExistence(mapping map[string]string, billStorage bool) error {
 	if mapping[odoo.InstanceHour] == "" {
 		return fmt.Errorf("missing UOM mapping %s", odoo.InstanceHour)
 	}
+	if billStorage && mapping[odoo.GBHour] == "" {
+		return fmt.Errorf("missing UOM mapping %s", odoo.GBHour)
+	}
 	return nil
 }
@@ -2,14 +2,21 @@ package exoscale
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vshn/billing-collector-cloudservices/pkg/exofixtures"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
 )
 
 func TestDBaaS_aggregatedDBaaS(t *testing.T) {
@@ -47,9 +54,20 @@ func TestDBaaS_aggregatedDBaaS(t *testing.T) {
 
 	expectedAggregatedOdooRecords := []odoo.OdooMeteredBillingRecord{record1, record2}
 
+	disk2 := record2
+	disk2.ProductID = "appcat-exoscale-v2-pg-disk"
+	disk2.UnitID = "uom-gb-hour"
+	disk2.ConsumedUnits = 50
+
+	backup2 := record2
+	backup2.ProductID = "appcat-exoscale-v2-pg-backup"
+	backup2.UnitID = "uom-gb-hour"
+	backup2.ConsumedUnits = 12
+
 	tests := map[string]struct {
 		dbaasDetails                  []Detail
 		exoscaleDBaaS                 []egoscale.DBAASServiceCommon
+		storage                       map[string]DBaaSStorage
 		expectedAggregatedOdooRecords []odoo.OdooMeteredBillingRecord
 	}{
 		"given DBaaS details and Exoscale DBaasS, we should get the ExpectedAggregatedDBaasS": {
@@ -74,15 +92,82 @@ func TestDBaaS_aggregatedDBaaS(t *testing.T) {
 					Name: "postgres-abc",
 					Type: egoscale.DBAASServiceTypeName(exofixtures.PostgresDBaaSType),
 					Plan: "hobbyist-2",
+					Zone: "ch-gva-2",
 				},
 				{
 					Name: "postgres-def",
 					Type: egoscale.DBAASServiceTypeName(exofixtures.PostgresDBaaSType),
 					Plan: "business-128",
+					Zone: "ch-gva-2",
 				},
 			},
 			expectedAggregatedOdooRecords: expectedAggregatedOdooRecords,
 		},
+		"given DBaaS with extra disk, we should get a disk record next to the instance record": {
+			dbaasDetails: []Detail{
+				{
+					Organization: "org2",
+					DBName:       "postgres-def",
+					Namespace:    "vshn-uvw",
+					Zone:         "ch-gva-2",
+					Kind:         "PostgreSQLList",
+				},
+			},
+			exoscaleDBaaS: []egoscale.DBAASServiceCommon{
+				{
+					Name: "postgres-def",
+					Type: egoscale.DBAASServiceTypeName(exofixtures.PostgresDBaaSType),
+					Plan: "business-128",
+					Zone: "ch-gva-2",
+				},
+			},
+			storage: map[string]DBaaSStorage{
+				"postgres-def": {ExtraDisk: 50 << 30},
+			},
+			expectedAggregatedOdooRecords: []odoo.OdooMeteredBillingRecord{record2, disk2},
+		},
+		"given DBaaS with backups, we should get a backup record next to the instance record": {
+			dbaasDetails: []Detail{
+				{
+					Organization: "org2",
+					DBName:       "postgres-def",
+					Namespace:    "vshn-uvw",
+					Zone:         "ch-gva-2",
+					Kind:         "PostgreSQLList",
+				},
+			},
+			exoscaleDBaaS: []egoscale.DBAASServiceCommon{
+				{
+					Name: "postgres-def",
+					Type: egoscale.DBAASServiceTypeName(exofixtures.PostgresDBaaSType),
+					Plan: "business-128",
+					Zone: "ch-gva-2",
+				},
+			},
+			storage: map[string]DBaaSStorage{
+				"postgres-def": {Backups: 12 << 30},
+			},
+			expectedAggregatedOdooRecords: []odoo.OdooMeteredBillingRecord{record2, backup2},
+		},
+		"given DBaaS without zone annotation, we should bill it in the zone reported by Exoscale": {
+			dbaasDetails: []Detail{
+				{
+					Organization: "org1",
+					DBName:       "postgres-abc",
+					Namespace:    "vshn-xyz",
+					Kind:         "PostgreSQLList",
+				},
+			},
+			exoscaleDBaaS: []egoscale.DBAASServiceCommon{
+				{
+					Name: "postgres-abc",
+					Type: egoscale.DBAASServiceTypeName(exofixtures.PostgresDBaaSType),
+					Plan: "hobbyist-2",
+					Zone: "ch-gva-2",
+				},
+			},
+			expectedAggregatedOdooRecords: []odoo.OdooMeteredBillingRecord{record1},
+		},
 		"given DBaaS details and different names in Exoscale DBaasS, we should not get the ExpectedAggregatedDBaasS": {
 			dbaasDetails: []Detail{
 				{
@@ -117,14 +202,61 @@ func TestDBaaS_aggregatedDBaaS(t *testing.T) {
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			ds, _ := NewDBaaS(nil, nil, nil, 1, "1234", "c-test1", "", map[string]string{})
-			aggregatedOdooRecords, err := ds.AggregateDBaaS(ctx, tc.exoscaleDBaaS, tc.dbaasDetails)
+			ds, _ := NewDBaaS(nil, nil, nil, "1234", "c-test1", "", map[string]string{odoo.GBHour: "uom-gb-hour"}, ZoneFetcher{}, unattributed.Policy{}, true, true)
+			aggregatedOdooRecords, err := ds.AggregateDBaaS(ctx, tc.exoscaleDBaaS, tc.dbaasDetails, tc.storage, now)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expectedAggregatedOdooRecords, aggregatedOdooRecords)
 		})
 	}
 }
 
+func TestDBaaS_extraDisk(t *testing.T) {
+	assert.Equal(t, int64(20<<30), extraDisk(egoscale.DBAASServiceCommon{DiskSize: 100 << 30}, 80<<30))
+	assert.Equal(t, int64(20<<30), extraDisk(egoscale.DBAASServiceCommon{DiskSize: 100 << 30, NodeCount: 3}, 80<<30))
+	assert.Equal(t, int64(0), extraDisk(egoscale.DBAASServiceCommon{DiskSize: 80 << 30}, 100<<30))
+}
+
+func TestDBaaS_storageFromAPIResponses(t *testing.T) {
+	readJSON := func(name string, v any) {
+		data, err := os.ReadFile(filepath.Join("testdata", "dbaas", name))
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, v))
+	}
+	services := egoscale.ListDBAASServicesResponse{}
+	readJSON("dbaas-service.json", &services)
+	serviceTypes := egoscale.ListDBAASServiceTypesResponse{}
+	readJSON("dbaas-service-type.json", &serviceTypes)
+	pg := egoscale.DBAASServicePG{}
+	readJSON("dbaas-service-pg.json", &pg)
+
+	planDiskSpace := planDiskSpaces("ch-gva-2", serviceTypes.DBAASServiceTypes)
+	extra := map[string]int64{}
+	for _, service := range services.DBAASServices {
+		diskSpace, ok := planDiskSpace[planKey(service.Zone, service.Type, service.Plan)]
+		require.True(t, ok, "plan of %s", service.Name)
+		extra[string(service.Name)] = extraDisk(service, diskSpace)
+	}
+
+	// the business-4 plan has two nodes with 80 GiB, the service was resized to 100 GiB
+	assert.Equal(t, map[string]int64{"postgres-abc": 20 << 30, "mysql-def": 0}, extra)
+	assert.Equal(t, int64(31<<30), backupSize(pg.Backups))
+}
+
+func TestDBaaS_countUnanswered(t *testing.T) {
+	ctx := getTestContext(t)
+
+	missing := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_zone_missing_total"}, []string{"kind"})
+	ds, _ := NewDBaaS(nil, nil, nil, "1234", "c-test1", "", map[string]string{}, ZoneFetcher{
+		Metrics: map[string]*prometheus.CounterVec{"providerZoneMissing": missing},
+	}, unattributed.Policy{}, false, false)
+
+	ds.countUnanswered(ctx, []egoscale.DBAASServiceCommon{{Name: "postgres-abc", Zone: "ch-gva-2"}}, []Detail{
+		{DBName: "postgres-abc", Kind: "PostgreSQLList"},
+		{DBName: "postgres-def", Kind: "PostgreSQLList"},
+	}, &ZoneError{Failed: map[string]error{"de-fra-1": assert.AnError}})
+	assert.Equal(t, 1.0, testutil.ToFloat64(missing.WithLabelValues("PostgreSQL")))
+}
+
 func getTestContext(t assert.TestingT) context.Context {
 	logger, err := log.NewLogger("test", time.Now().String(), 1, "console")
 	assert.NoError(t, err, "cannot create logger")
@@ -0,0 +1,101 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/vshn/billing-collector-cloudservices/pkg/controlAPI"
+	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/scheduler"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// clusterLabel is the Exoscale label with the id of the cluster a resource belongs to
+	clusterLabel = "appuio-cluster-id"
+	// computeNamespaceLabel is the Exoscale label with the namespace a resource is billed to.
+	// Exoscale label keys cannot contain slashes, so namespaceLabel cannot be used.
+	computeNamespaceLabel = "appuio-namespace"
+)
+
+// labelAttribution attributes Exoscale resources without a matching object in the cluster, e.g. compute instances, with their labels
+type labelAttribution struct {
+	controlApiClient k8s.Client
+	salesOrder       string
+	clusterId        string
+	cloudZone        string
+}
+
+// inCluster returns whether a resource is labelled with the cluster id
+func (a labelAttribution) inCluster(labels egoscale.Labels) bool {
+	return labels[clusterLabel] == a.clusterId
+}
+
+// attribute returns the item group and sales order of a resource from its namespace label.
+// On APPUiO Managed the namespace is optional as the whole cluster is billed to the same sales order.
+func (a labelAttribution) attribute(ctx context.Context, labels egoscale.Labels, namespaces map[string]string) (itemGroup, salesOrder string, ok bool) {
+	logger := log.Logger(ctx)
+
+	namespace := labels[computeNamespaceLabel]
+	if a.salesOrder != "" {
+		if namespace == "" {
+			return fmt.Sprintf("APPUiO Managed - Cluster: %s", a.clusterId), a.salesOrder, true
+		}
+		return fmt.Sprintf("APPUiO Managed - Cluster: %s / Namespace: %s", a.clusterId, namespace), a.salesOrder, true
+	}
+
+	if namespace == "" {
+		logger.Info("Namespace label is missing in resource, skipping...", "label", computeNamespaceLabel)
+		return "", "", false
+	}
+	organization, exists := namespaces[namespace]
+	if !exists {
+		logger.Info("Namespace not found in namespace list, skipping...", "namespace", namespace)
+		return "", "", false
+	}
+	salesOrder, err := controlAPI.GetSalesOrder(ctx, a.controlApiClient, organization)
+	if err != nil {
+		logger.Error(err, "Unable to sync resource, cannot get salesOrder", "namespace", namespace)
+		return "", "", false
+	}
+	return fmt.Sprintf("APPUiO Cloud - Zone: %s / Namespace: %s", a.cloudZone, namespace), salesOrder, true
+}
+
+func fetchNamespaces(ctx context.Context, k8sClient k8s.Client) (map[string]string, error) {
+	log.Logger(ctx).V(1).Info("Listing namespaces from cluster")
+	namespaces, err := kubernetes.FetchNamespaceWithOrganizationMap(ctx, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list namespaces: %w", err)
+	}
+	return namespaces, nil
+}
+
+// endpointZone returns the zone of an endpoint created by zoneEndpoint
+func endpointZone(zones []string, endpoint egoscale.Endpoint) string {
+	for _, zone := range zones {
+		if zoneEndpoint(zone) == endpoint {
+			return zone
+		}
+	}
+	return ""
+}
+
+// hourTimeRange returns the time range of the billing hour, aligned to the location of billingHour, i.e. the timezone of the schedule
+func hourTimeRange(billingHour time.Time) odoo.TimeRange {
+	from := scheduler.Hourly.Truncate(billingHour)
+	return odoo.TimeRange{
+		From: from.In(time.UTC),
+		To:   scheduler.Hourly.Next(from).In(time.UTC),
+	}
+}
+
+func CheckInstanceHourUOMExistence(mapping map[string]string) error {
+	if mapping[odoo.InstanceHour] == "" {
+		return fmt.Errorf("missing UOM mapping %s", odoo.InstanceHour)
+	}
+	return nil
+}
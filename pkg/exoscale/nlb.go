@@ -0,0 +1,114 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const productIdPrefixNLB = "appcat-exoscale-nlb"
+
+// LoadBalancer is an Exoscale Network Load Balancer in a zone
+type LoadBalancer struct {
+	egoscale.LoadBalancer
+	Zone string
+}
+
+// NLB gathers the Network Load Balancers labelled with the cluster id from Exoscale
+type NLB struct {
+	labelAttribution
+	exoscaleClient *egoscale.Client
+	k8sClient      k8s.Client
+	uomMapping     map[string]string
+	zones          ZoneFetcher
+}
+
+// NewNLB creates an NLB with the initial setup
+func NewNLB(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, zones ZoneFetcher) (*NLB, error) {
+	return &NLB{
+		labelAttribution: labelAttribution{
+			controlApiClient: controlApiClient,
+			salesOrder:       salesOrder,
+			clusterId:        clusterId,
+			cloudZone:        cloudZone,
+		},
+		exoscaleClient: exoscaleClient,
+		k8sClient:      k8sClient,
+		uomMapping:     uomMapping,
+		zones:          zones,
+	}, nil
+}
+
+// GetMetrics returns the billing records of the given hour.
+// Exoscale only reports the current load balancers, so the records of past hours are based on them as well.
+// If some zones could not be fetched, the records of the others are returned together with the error.
+func (n *NLB) GetMetrics(ctx context.Context, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	namespaces, err := fetchNamespaces(ctx, n.k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Logger(ctx).Info("Fetching Network Load Balancers from Exoscale")
+	loadBalancers, fetchErr := fetchZones(ctx, n.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]LoadBalancer, error) {
+		resp, err := n.exoscaleClient.WithEndpoint(endpoint).ListLoadBalancers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		zone := endpointZone(n.zones.Zones, endpoint)
+		loadBalancers := make([]LoadBalancer, 0, len(resp.LoadBalancers))
+		for _, lb := range resp.LoadBalancers {
+			loadBalancers = append(loadBalancers, LoadBalancer{LoadBalancer: lb, Zone: zone})
+		}
+		return loadBalancers, nil
+	})
+	zoneErr := &ZoneError{}
+	if fetchErr != nil && !errors.As(fetchErr, &zoneErr) {
+		return nil, fmt.Errorf("fetchLoadBalancers: %w", fetchErr)
+	}
+
+	records, err := n.AggregateNLB(ctx, loadBalancers, namespaces, billingHour)
+	if err != nil {
+		return nil, err
+	}
+	if fetchErr != nil {
+		return records, fmt.Errorf("fetchLoadBalancers: %w", fetchErr)
+	}
+	return records, nil
+}
+
+// AggregateNLB creates the billing records of the Network Load Balancers labelled with the cluster id for the given billing hour
+func (n *NLB) AggregateNLB(ctx context.Context, loadBalancers []LoadBalancer, namespaces map[string]string, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	log.Logger(ctx).Info("Aggregating Network Load Balancers by namespace")
+
+	timeRange := hourTimeRange(billingHour)
+
+	records := make([]odoo.OdooMeteredBillingRecord, 0)
+	for _, lb := range loadBalancers {
+		if !n.inCluster(lb.Labels) {
+			continue
+		}
+
+		itemGroup, salesOrder, ok := n.attribute(ctx, lb.Labels, namespaces)
+		if !ok {
+			continue
+		}
+		records = append(records, odoo.OdooMeteredBillingRecord{
+			ProductID:            productIdPrefixNLB + "-instance",
+			InstanceID:           fmt.Sprintf("%s/%s", lb.Zone, lb.ID),
+			ItemDescription:      lb.Name,
+			ItemGroupDescription: itemGroup,
+			SalesOrder:           salesOrder,
+			UnitID:               n.uomMapping[odoo.InstanceHour],
+			ConsumedUnits:        1,
+			TimeRange:            timeRange,
+		})
+	}
+
+	return records, nil
+}
@@ -3,6 +3,8 @@ package exoscale
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	egoscale "github.com/exoscale/egoscale/v3"
@@ -12,6 +14,7 @@ import (
 	"github.com/vshn/billing-collector-cloudservices/pkg/kubernetes"
 	"github.com/vshn/billing-collector-cloudservices/pkg/log"
 	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	"github.com/vshn/billing-collector-cloudservices/pkg/unattributed"
 	exoscalev1 "github.com/vshn/provider-exoscale/apis/exoscale/v1"
 
 	k8s "sigs.k8s.io/controller-runtime/pkg/client"
@@ -31,15 +34,18 @@ type ObjectStorage struct {
 	cloudZone        string
 	uomMapping       map[string]string
 	providerMetrics  map[string]prometheus.Counter
+	unattributed     unattributed.Policy
 }
 
 // BucketDetail a k8s bucket object with relevant data
 type BucketDetail struct {
 	Organization, BucketName, Namespace, Zone string
+	// SalesOrder is set if the unattributed usage policy bills the bucket to a dedicated sales order
+	SalesOrder string
 }
 
 // NewObjectStorage creates an ObjectStorage with the initial setup
-func NewObjectStorage(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, providerMetrics map[string]prometheus.Counter) (*ObjectStorage, error) {
+func NewObjectStorage(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, providerMetrics map[string]prometheus.Counter, unattributedPolicy unattributed.Policy) (*ObjectStorage, error) {
 	return &ObjectStorage{
 		k8sClient:        k8sClient,
 		exoscaleClient:   exoscaleClient,
@@ -49,10 +55,13 @@ func NewObjectStorage(exoscaleClient *egoscale.Client, k8sClient k8s.Client, con
 		cloudZone:        cloudZone,
 		uomMapping:       uomMapping,
 		providerMetrics:  providerMetrics,
+		unattributed:     unattributedPolicy,
 	}, nil
 }
 
-func (o *ObjectStorage) GetMetrics(ctx context.Context) ([]odoo.OdooMeteredBillingRecord, error) {
+// GetMetrics returns the billing records of the given day.
+// Exoscale only reports the current bucket usage, so the records of past days are based on it as well.
+func (o *ObjectStorage) GetMetrics(ctx context.Context, billingDate time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	detail, err := o.fetchManagedBucketsAndNamespaces(ctx)
 	if err != nil {
 		o.providerMetrics["providerFailed"].Inc()
@@ -61,7 +70,7 @@ func (o *ObjectStorage) GetMetrics(ctx context.Context) ([]odoo.OdooMeteredBilli
 		o.providerMetrics["providerSucceeded"].Inc()
 	}
 
-	metrics, err := o.getBucketUsage(ctx, detail)
+	metrics, err := o.getBucketUsage(ctx, detail, billingDate)
 	if err != nil {
 		return nil, fmt.Errorf("getBucketUsage: %w", err)
 	}
@@ -70,7 +79,7 @@ func (o *ObjectStorage) GetMetrics(ctx context.Context) ([]odoo.OdooMeteredBilli
 
 // getBucketUsage gets bucket usage from Exoscale and matches them with the bucket from the cluster
 // If there are no buckets in Exoscale, the API will return an empty slice
-func (o *ObjectStorage) getBucketUsage(ctx context.Context, bucketDetails []BucketDetail) ([]odoo.OdooMeteredBillingRecord, error) {
+func (o *ObjectStorage) getBucketUsage(ctx context.Context, bucketDetails []BucketDetail, billingDate time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	logger := log.Logger(ctx)
 	logger.Info("Fetching bucket usage from Exoscale")
 
@@ -82,7 +91,7 @@ func (o *ObjectStorage) getBucketUsage(ctx context.Context, bucketDetails []Buck
 		o.providerMetrics["providerSucceeded"].Inc()
 	}
 
-	odooMetrics, err := o.getOdooMeteredBillingRecords(ctx, resp.SOSBucketsUsage, bucketDetails)
+	odooMetrics, err := o.getOdooMeteredBillingRecords(ctx, resp.SOSBucketsUsage, bucketDetails, billingDate)
 	if err != nil {
 		return nil, err
 	}
@@ -94,7 +103,10 @@ func (o *ObjectStorage) getBucketUsage(ctx context.Context, bucketDetails []Buck
 	return odooMetrics, nil
 }
 
-func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBucketsUsage []egoscale.SOSBucketUsage, bucketDetails []BucketDetail) ([]odoo.OdooMeteredBillingRecord, error) {
+// getOdooMeteredBillingRecords bills the storage of the buckets.
+// Unlike cloudscale, Exoscale does not report egress traffic or request counts per bucket: ListSOSBucketsUsage only returns the size,
+// so traffic and requests cannot be billed until the API exposes them.
+func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBucketsUsage []egoscale.SOSBucketUsage, bucketDetails []BucketDetail, billingDate time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
 	logger := log.Logger(ctx)
 	logger.Info("Aggregating buckets by namespace")
 
@@ -103,15 +115,10 @@ func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBuc
 		sosBucketsUsageMap[usage.Name] = usage
 	}
 
-	location, err := time.LoadLocation("Europe/Zurich")
-	if err != nil {
-		return nil, fmt.Errorf("load loaction: %w", err)
-	}
-
-	now := time.Now().In(location)
-	billingDate := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location()).In(time.UTC)
+	// the billing date is in the location of the schedule, which the days are aligned to
+	billingDate = time.Date(billingDate.Year(), billingDate.Month(), billingDate.Day(), 0, 0, 0, 0, billingDate.Location()).In(time.UTC)
 
-	aggregatedBuckets := make([]odoo.OdooMeteredBillingRecord, 0)
+	bucketRecords := make([]odoo.OdooMeteredBillingRecord, 0)
 	for _, bucketDetail := range bucketDetails {
 		logger.V(1).Info("Checking bucket", "bucket", bucketDetail.BucketName)
 
@@ -125,8 +132,13 @@ func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBuc
 			itemGroup := fmt.Sprintf("APPUiO Managed - Cluster: %s / Namespace: %s", o.clusterId, bucketDetail.Namespace)
 			instanceId := fmt.Sprintf("%s/%s", bucketDetail.Zone, bucketDetail.BucketName)
 			salesOrder := o.salesOrder
-			if salesOrder == "" {
+			if bucketDetail.SalesOrder != "" {
+				salesOrder = bucketDetail.SalesOrder
+			}
+			if o.salesOrder == "" {
 				itemGroup = fmt.Sprintf("APPUiO Cloud - Zone: %s / Namespace: %s", o.cloudZone, bucketDetail.Namespace)
+			}
+			if salesOrder == "" {
 				salesOrder, err = controlAPI.GetSalesOrder(ctx, o.controlApiClient, bucketDetail.Organization)
 				if err != nil {
 					logger.Error(err, "unable to sync bucket", "namespace", bucketDetail.Namespace)
@@ -135,7 +147,6 @@ func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBuc
 			}
 
 			o := odoo.OdooMeteredBillingRecord{
-				ProductID:            getProductId(value),
 				InstanceID:           instanceId + "/storage",
 				ItemDescription:      bucketDetail.BucketName,
 				ItemGroupDescription: itemGroup,
@@ -148,27 +159,61 @@ func (o *ObjectStorage) getOdooMeteredBillingRecords(ctx context.Context, sosBuc
 				},
 			}
 
-			aggregatedBuckets = append(aggregatedBuckets, o)
+			bucketRecords = append(bucketRecords, o)
 
 		} else {
 			logger.Info("Could not find any bucket on exoscale", "bucket", bucketDetail.BucketName)
 		}
 	}
-	return aggregatedBuckets, nil
+	return splitStorageTiers(bucketRecords), nil
 }
 
-// getProductId calculates the tier based on the bucket storage consumption
+// storageTiers are the Exoscale object storage price tiers with the total consumption up to which they apply, in GiB.
 // For more details https://www.exoscale.com/object-storage/
-// Value is passed as GiB
-func getProductId(value float64) string {
-	valueTB := value / 1024
-	if valueTB < 512 {
-		return productIdStorageTier1
-	} else if valueTB > 1024 {
-		return productIdStorageTier3
-	} else {
-		return productIdStorageTier2
+var storageTiers = []struct {
+	productId string
+	upTo      float64
+}{
+	{productIdStorageTier1, 512 * 1024},
+	{productIdStorageTier2, 1024 * 1024},
+	{productIdStorageTier3, math.Inf(1)},
+}
+
+// splitStorageTiers assigns the tier product ids to the bucket records of a day.
+// The tiers depend on the total consumption of a sales order, so the buckets of a sales order fill the tiers one after the other,
+// in the order of their instance id so that the same usage always gets the same tiers.
+// A bucket which crosses a tier boundary is split into a record per tier.
+func splitStorageTiers(bucketRecords []odoo.OdooMeteredBillingRecord) []odoo.OdooMeteredBillingRecord {
+	sort.SliceStable(bucketRecords, func(i, j int) bool {
+		if bucketRecords[i].SalesOrder != bucketRecords[j].SalesOrder {
+			return bucketRecords[i].SalesOrder < bucketRecords[j].SalesOrder
+		}
+		return bucketRecords[i].InstanceID < bucketRecords[j].InstanceID
+	})
+
+	records := make([]odoo.OdooMeteredBillingRecord, 0, len(bucketRecords))
+	consumed := map[string]float64{}
+	for _, bucket := range bucketRecords {
+		from := consumed[bucket.SalesOrder]
+		to := from + bucket.ConsumedUnits
+		consumed[bucket.SalesOrder] = to
+
+		for _, tier := range storageTiers {
+			if from >= tier.upTo {
+				// the tier is already filled by other buckets
+				continue
+			}
+			record := bucket
+			record.ProductID = tier.productId
+			record.ConsumedUnits = math.Min(to, tier.upTo) - from
+			records = append(records, record)
+			if to <= tier.upTo {
+				break
+			}
+			from = tier.upTo
+		}
 	}
+	return records
 }
 
 func (o *ObjectStorage) fetchManagedBucketsAndNamespaces(ctx context.Context) ([]BucketDetail, error) {
@@ -188,10 +233,14 @@ func (o *ObjectStorage) fetchManagedBucketsAndNamespaces(ctx context.Context) ([
 		return nil, fmt.Errorf("cannot list namespaces: %w", err)
 	}
 
-	return addOrgAndNamespaceToBucket(ctx, buckets, namespaces), nil
+	report := o.unattributed.NewReport()
+	defer report.Log(ctx)
+	return addOrgAndNamespaceToBucket(ctx, report, buckets, namespaces), nil
 }
 
-func addOrgAndNamespaceToBucket(ctx context.Context, buckets exoscalev1.BucketList, namespaces map[string]string) []BucketDetail {
+// addOrgAndNamespaceToBucket returns the details of the buckets with the organization of their namespace.
+// If a bucket has no namespace or the namespace is unknown, the unattributed usage policy decides whether and how it is billed.
+func addOrgAndNamespaceToBucket(ctx context.Context, report *unattributed.Report, buckets exoscalev1.BucketList, namespaces map[string]string) []BucketDetail {
 	logger := log.Logger(ctx)
 	logger.V(1).Info("Gathering org and namespace from buckets")
 
@@ -201,24 +250,26 @@ func addOrgAndNamespaceToBucket(ctx context.Context, buckets exoscalev1.BucketLi
 			BucketName: bucket.Spec.ForProvider.BucketName,
 			Zone:       bucket.Spec.ForProvider.Zone,
 		}
-		if namespace, exist := bucket.ObjectMeta.Labels[namespaceLabel]; exist {
-			organization, ok := namespaces[namespace]
-			if !ok {
-				// cannot find namespace in namespace list
-				logger.Info("Namespace not found in namespace list, skipping...",
+		namespace, exist := bucket.ObjectMeta.Labels[namespaceLabel]
+		organization, ok := namespaces[namespace]
+		if !exist || !ok {
+			reason := "namespace not found in namespace list"
+			if !exist {
+				reason = fmt.Sprintf("namespace label %s is missing", namespaceLabel)
+			}
+			decision := report.Decide("Bucket", bucket.Name, reason)
+			if decision.Action == unattributed.Skip {
+				logger.Info("Bucket could not be attributed, skipping...",
 					"namespace", namespace,
-					"bucket", bucket.Name)
+					"bucket", bucket.Name,
+					"reason", reason)
 				continue
 			}
-			bucketDetail.Namespace = namespace
-			bucketDetail.Organization = organization
-		} else {
-			// cannot get namespace from bucket
-			logger.Info("Namespace label is missing in bucket, skipping...",
-				"label", namespaceLabel,
-				"bucket", bucket.Name)
-			continue
+			organization = decision.Organization
+			bucketDetail.SalesOrder = decision.SalesOrder
 		}
+		bucketDetail.Namespace = namespace
+		bucketDetail.Organization = organization
 		logger.V(1).Info("Added namespace and organization to bucket",
 			"bucket", bucket.Name,
 			"namespace", bucketDetail.Namespace,
@@ -1,37 +1,85 @@
 package exoscale
 
 import (
-	"github.com/stretchr/testify/assert"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
 )
 
-func TestObjectStorage_getProductId(t *testing.T) {
+func TestObjectStorage_splitStorageTiers(t *testing.T) {
+	bucket := func(salesOrder, name string, value float64) odoo.OdooMeteredBillingRecord {
+		return odoo.OdooMeteredBillingRecord{
+			InstanceID:    "ch-gva-2/" + name + "/storage",
+			SalesOrder:    salesOrder,
+			ConsumedUnits: value, // in GiB
+		}
+	}
+	tier := func(record odoo.OdooMeteredBillingRecord, productId string, value float64) odoo.OdooMeteredBillingRecord {
+		record.ProductID = productId
+		record.ConsumedUnits = value
+		return record
+	}
+
 	tests := map[string]struct {
-		value      float64 // in GiB
-		expectTier string
+		buckets  []odoo.OdooMeteredBillingRecord
+		expected []odoo.OdooMeteredBillingRecord
 	}{
 		"given SOS with below 512TiB capacity, we should get the Product Tier 1": {
-			value:      300.1, // in GiB
-			expectTier: productIdStorageTier1,
+			buckets:  []odoo.OdooMeteredBillingRecord{bucket("1234", "a", 300.1)},
+			expected: []odoo.OdooMeteredBillingRecord{tier(bucket("1234", "a", 0), productIdStorageTier1, 300.1)},
+		},
+		"given SOS with 0 capacity, we should get the Product Tier 1": {
+			buckets:  []odoo.OdooMeteredBillingRecord{bucket("1234", "a", 0)},
+			expected: []odoo.OdooMeteredBillingRecord{tier(bucket("1234", "a", 0), productIdStorageTier1, 0)},
 		},
-		"given SOS with above 512 TiB and below 1Pib capacity, we should get the Product Tier 2": {
-			value:      813000.4, // in GiB
-			expectTier: productIdStorageTier2,
+		"given SOS with above 1PiB capacity, we should split it into all tiers": {
+			buckets: []odoo.OdooMeteredBillingRecord{bucket("1234", "a", 1300345.6)},
+			expected: []odoo.OdooMeteredBillingRecord{
+				tier(bucket("1234", "a", 0), productIdStorageTier1, 524288),
+				tier(bucket("1234", "a", 0), productIdStorageTier2, 524288),
+				tier(bucket("1234", "a", 0), productIdStorageTier3, 251769.6),
+			},
 		},
-		"given SOS with above 1Pib capacity, we should get the Product Tier 3": {
-			value:      1300345.6, // in GiB
-			expectTier: productIdStorageTier3,
+		"given many small buckets of a sales order, we should reach the higher tiers with their total": {
+			buckets: []odoo.OdooMeteredBillingRecord{
+				bucket("1234", "c", 400000),
+				bucket("1234", "a", 300000),
+				bucket("1234", "b", 400000),
+			},
+			expected: []odoo.OdooMeteredBillingRecord{
+				tier(bucket("1234", "a", 0), productIdStorageTier1, 300000),
+				tier(bucket("1234", "b", 0), productIdStorageTier1, 224288),
+				tier(bucket("1234", "b", 0), productIdStorageTier2, 175712),
+				tier(bucket("1234", "c", 0), productIdStorageTier2, 348576),
+				tier(bucket("1234", "c", 0), productIdStorageTier3, 51424),
+			},
 		},
-		"given SOS with below 0 capacity, we should get the Product Tier 1": {
-			value:      0, // in GiB
-			expectTier: productIdStorageTier1,
+		"given buckets of different sales orders, we should count their consumption separately": {
+			buckets: []odoo.OdooMeteredBillingRecord{
+				bucket("5678", "a", 500000),
+				bucket("1234", "b", 500000),
+				bucket("1234", "a", 500000),
+			},
+			expected: []odoo.OdooMeteredBillingRecord{
+				tier(bucket("1234", "a", 0), productIdStorageTier1, 500000),
+				tier(bucket("1234", "b", 0), productIdStorageTier1, 24288),
+				tier(bucket("1234", "b", 0), productIdStorageTier2, 475712),
+				tier(bucket("5678", "a", 0), productIdStorageTier1, 500000),
+			},
 		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
-			tier := getProductId(tc.value)
-			assert.Equal(t, tc.expectTier, tier)
+			records := splitStorageTiers(tc.buckets)
+			assert.Len(t, records, len(tc.expected))
+			for i := range tc.expected {
+				assert.Equal(t, tc.expected[i].InstanceID, records[i].InstanceID)
+				assert.Equal(t, tc.expected[i].SalesOrder, records[i].SalesOrder)
+				assert.Equal(t, tc.expected[i].ProductID, records[i].ProductID)
+				assert.InDelta(t, tc.expected[i].ConsumedUnits, records[i].ConsumedUnits, 0.001)
+			}
 		})
 	}
 }
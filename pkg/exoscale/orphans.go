@@ -0,0 +1,94 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/orphans"
+	exoscalev1 "github.com/vshn/provider-exoscale/apis/exoscale/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const bucketKind = "Bucket"
+
+// FindOrphans returns the Exoscale DBaaS services which have no matching object in the cluster.
+// If some zones could not be fetched, the orphans of the others are returned together with a *ZoneError.
+func (ds *DBaaS) FindOrphans(ctx context.Context) (kinds []string, orphaned []orphans.Resource, err error) {
+	logger := log.Logger(ctx)
+
+	for _, kind := range dbaasTypes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	claimed := map[string]bool{}
+	for _, gvk := range groupVersionKinds {
+		metaList := &metav1.PartialObjectMetadataList{}
+		metaList.SetGroupVersionKind(gvk)
+		if err := ds.k8sClient.List(ctx, metaList); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return kinds, nil, fmt.Errorf("cannot list managed resource kind %s from cluster: %w", gvk.Kind, err)
+		}
+		for _, item := range metaList.Items {
+			claimed[item.GetName()] = true
+		}
+	}
+
+	usage, err := ds.fetchDBaaSUsage(ctx)
+	zoneErr := &ZoneError{}
+	if err != nil && !errors.As(err, &zoneErr) {
+		return kinds, nil, fmt.Errorf("fetchDBaaSUsage: %w", err)
+	}
+
+	resources := make([]orphans.Resource, 0, len(usage))
+	for _, service := range usage {
+		resources = append(resources, orphans.Resource{
+			Kind: dbaasTypes[string(service.Type)],
+			Name: string(service.Name),
+			Zone: service.Zone,
+		})
+	}
+	orphaned = orphans.Find(resources, claimed)
+	logger.Info("Checked DBaaS services for orphans", "services", len(resources), "orphans", len(orphaned))
+	return kinds, orphaned, err
+}
+
+// FindOrphans returns the Exoscale SOS buckets which have no matching Bucket in the cluster
+func (o *ObjectStorage) FindOrphans(ctx context.Context) (kinds []string, orphaned []orphans.Resource, err error) {
+	logger := log.Logger(ctx)
+	kinds = []string{bucketKind}
+
+	buckets := exoscalev1.BucketList{}
+	if err := o.k8sClient.List(ctx, &buckets); err != nil {
+		return kinds, nil, fmt.Errorf("cannot list buckets: %w", err)
+	}
+	claimed := make(map[string]bool, len(buckets.Items))
+	for _, bucket := range buckets.Items {
+		claimed[bucket.Spec.ForProvider.BucketName] = true
+	}
+
+	resp, err := o.exoscaleClient.ListSOSBucketsUsage(ctx)
+	if err != nil {
+		o.providerMetrics["providerFailed"].Inc()
+		return kinds, nil, fmt.Errorf("cannot list bucket usage: %w", err)
+	}
+	o.providerMetrics["providerSucceeded"].Inc()
+
+	resources := make([]orphans.Resource, 0, len(resp.SOSBucketsUsage))
+	for _, usage := range resp.SOSBucketsUsage {
+		resources = append(resources, orphans.Resource{
+			Kind: bucketKind,
+			Name: usage.Name,
+			Zone: string(usage.ZoneName),
+		})
+	}
+	orphaned = orphans.Find(resources, claimed)
+	logger.Info("Checked SOS buckets for orphans", "buckets", len(resources), "orphans", len(orphaned))
+	return kinds, orphaned, nil
+}
@@ -0,0 +1,115 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+	k8s "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const productIdPrefixSKS = "appcat-exoscale-sks"
+
+// SKSCluster is an Exoscale SKS cluster in a zone
+type SKSCluster struct {
+	egoscale.SKSCluster
+	Zone string
+}
+
+// SKS gathers the SKS clusters labelled with the cluster id from Exoscale
+type SKS struct {
+	labelAttribution
+	exoscaleClient *egoscale.Client
+	k8sClient      k8s.Client
+	uomMapping     map[string]string
+	zones          ZoneFetcher
+}
+
+// NewSKS creates an SKS with the initial setup
+func NewSKS(exoscaleClient *egoscale.Client, k8sClient k8s.Client, controlApiClient k8s.Client, salesOrder, clusterId string, cloudZone string, uomMapping map[string]string, zones ZoneFetcher) (*SKS, error) {
+	return &SKS{
+		labelAttribution: labelAttribution{
+			controlApiClient: controlApiClient,
+			salesOrder:       salesOrder,
+			clusterId:        clusterId,
+			cloudZone:        cloudZone,
+		},
+		exoscaleClient: exoscaleClient,
+		k8sClient:      k8sClient,
+		uomMapping:     uomMapping,
+		zones:          zones,
+	}, nil
+}
+
+// GetMetrics returns the billing records of the given hour.
+// Exoscale only reports the current clusters, so the records of past hours are based on them as well.
+// If some zones could not be fetched, the records of the others are returned together with the error.
+func (s *SKS) GetMetrics(ctx context.Context, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	namespaces, err := fetchNamespaces(ctx, s.k8sClient)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Logger(ctx).Info("Fetching SKS clusters from Exoscale")
+	clusters, fetchErr := fetchZones(ctx, s.zones, func(ctx context.Context, endpoint egoscale.Endpoint) ([]SKSCluster, error) {
+		resp, err := s.exoscaleClient.WithEndpoint(endpoint).ListSKSClusters(ctx)
+		if err != nil {
+			return nil, err
+		}
+		zone := endpointZone(s.zones.Zones, endpoint)
+		clusters := make([]SKSCluster, 0, len(resp.SKSClusters))
+		for _, cluster := range resp.SKSClusters {
+			clusters = append(clusters, SKSCluster{SKSCluster: cluster, Zone: zone})
+		}
+		return clusters, nil
+	})
+	zoneErr := &ZoneError{}
+	if fetchErr != nil && !errors.As(fetchErr, &zoneErr) {
+		return nil, fmt.Errorf("fetchSKSClusters: %w", fetchErr)
+	}
+
+	records, err := s.AggregateSKS(ctx, clusters, namespaces, billingHour)
+	if err != nil {
+		return nil, err
+	}
+	if fetchErr != nil {
+		return records, fmt.Errorf("fetchSKSClusters: %w", fetchErr)
+	}
+	return records, nil
+}
+
+// AggregateSKS creates the billing records of the SKS clusters labelled with the cluster id for the given billing hour.
+// The control plane is billed per hour and service level.
+func (s *SKS) AggregateSKS(ctx context.Context, clusters []SKSCluster, namespaces map[string]string, billingHour time.Time) ([]odoo.OdooMeteredBillingRecord, error) {
+	log.Logger(ctx).Info("Aggregating SKS clusters by namespace")
+
+	timeRange := hourTimeRange(billingHour)
+
+	records := make([]odoo.OdooMeteredBillingRecord, 0)
+	for _, cluster := range clusters {
+		if !s.inCluster(cluster.Labels) {
+			continue
+		}
+
+		itemGroup, salesOrder, ok := s.attribute(ctx, cluster.Labels, namespaces)
+		if !ok {
+			continue
+		}
+		records = append(records, odoo.OdooMeteredBillingRecord{
+			ProductID:            fmt.Sprintf("%s-%s", productIdPrefixSKS, cluster.Level),
+			InstanceID:           fmt.Sprintf("%s/%s", cluster.Zone, cluster.ID),
+			ItemDescription:      cluster.Name,
+			ItemGroupDescription: itemGroup,
+			SalesOrder:           salesOrder,
+			UnitID:               s.uomMapping[odoo.InstanceHour],
+			ConsumedUnits:        1,
+			TimeRange:            timeRange,
+		})
+	}
+
+	return records, nil
+}
@@ -0,0 +1,51 @@
+package exoscale
+
+import (
+	"testing"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/odoo"
+)
+
+func TestSKSAndNLB_Aggregate(t *testing.T) {
+	ctx := getTestContext(t)
+
+	location, _ := time.LoadLocation("Europe/Zurich")
+	hour := time.Date(2024, 3, 5, 10, 0, 0, 0, location)
+	timeRange := odoo.TimeRange{
+		From: hour.In(time.UTC),
+		To:   hour.Add(time.Hour).In(time.UTC),
+	}
+	uom := map[string]string{odoo.InstanceHour: "uom-instance-hour"}
+
+	t.Run("given SKS clusters, we should bill the control plane level of the clusters labelled with the cluster id", func(t *testing.T) {
+		s, _ := NewSKS(nil, nil, nil, "1234", "c-test1", "", uom, ZoneFetcher{})
+		records, err := s.AggregateSKS(ctx, []SKSCluster{
+			{SKSCluster: egoscale.SKSCluster{ID: "a", Name: "sks-a", Level: egoscale.SKSClusterLevelPro, Labels: egoscale.Labels{clusterLabel: "c-test1", computeNamespaceLabel: "vshn-xyz"}}, Zone: "ch-gva-2"},
+			{SKSCluster: egoscale.SKSCluster{ID: "b", Name: "sks-b", Level: egoscale.SKSClusterLevelStarter, Labels: egoscale.Labels{clusterLabel: "c-other"}}, Zone: "ch-gva-2"},
+		}, map[string]string{}, hour)
+		require.NoError(t, err)
+		assert.Equal(t, []odoo.OdooMeteredBillingRecord{{
+			ProductID:            "appcat-exoscale-sks-pro",
+			InstanceID:           "ch-gva-2/a",
+			ItemDescription:      "sks-a",
+			ItemGroupDescription: "APPUiO Managed - Cluster: c-test1 / Namespace: vshn-xyz",
+			SalesOrder:           "1234",
+			UnitID:               "uom-instance-hour",
+			ConsumedUnits:        1,
+			TimeRange:            timeRange,
+		}}, records)
+	})
+
+	t.Run("given load balancers without namespace on APPUiO Cloud, we should skip them", func(t *testing.T) {
+		n, _ := NewNLB(nil, nil, nil, "", "c-test1", "c-zone", uom, ZoneFetcher{})
+		records, err := n.AggregateNLB(ctx, []LoadBalancer{
+			{LoadBalancer: egoscale.LoadBalancer{ID: "a", Name: "nlb-a", Labels: egoscale.Labels{clusterLabel: "c-test1"}}, Zone: "de-fra-1"},
+		}, map[string]string{}, hour)
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+}
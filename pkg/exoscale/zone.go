@@ -0,0 +1,132 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+// DefaultZones are the Exoscale zones fetched if no other zones are configured
+var DefaultZones = []string{
+	"ch-gva-2",
+	"ch-dk-2",
+	"de-fra-1",
+	"de-muc-1",
+	"at-vie-1",
+	"at-vie-2",
+	"bg-sof-1",
+}
+
+const (
+	DefaultZoneWorkers = 4
+	DefaultZoneTimeout = 30 * time.Second
+)
+
+// ZoneFetcher fetches resources from several Exoscale zones concurrently
+type ZoneFetcher struct {
+	// Zones to fetch, e.g. ch-gva-2
+	Zones []string
+	// Workers is the maximum number of zones fetched at the same time
+	Workers int
+	// Timeout of fetching a single zone
+	Timeout time.Duration
+	// Metrics count the failed and successful requests per zone, and the resources skipped because their zone is missing
+	Metrics map[string]*prometheus.CounterVec
+}
+
+// ZoneError is returned if some Exoscale zones could not be fetched while others answered
+type ZoneError struct {
+	// Failed maps the zone name to its error
+	Failed map[string]error
+}
+
+func (e *ZoneError) Error() string {
+	zones := make([]string, 0, len(e.Failed))
+	for zone := range e.Failed {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	msgs := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", zone, e.Failed[zone]))
+	}
+	return fmt.Sprintf("%d zones failed: %s", len(zones), strings.Join(msgs, "; "))
+}
+
+// zoneEndpoint returns the Exoscale API endpoint of a zone, e.g. https://api-ch-gva-2.exoscale.com/v2 for ch-gva-2
+func zoneEndpoint(zone string) egoscale.Endpoint {
+	return egoscale.Endpoint(fmt.Sprintf("https://api-%s.exoscale.com/v2", zone))
+}
+
+// fetchZones calls list for every zone concurrently and returns the merged results of the zones which answered, in the order of the zones.
+// If at least one zone failed, a *ZoneError is returned as well, if all of them failed no results are returned.
+func fetchZones[T any](ctx context.Context, f ZoneFetcher, list func(context.Context, egoscale.Endpoint) ([]T, error)) ([]T, error) {
+	logger := log.Logger(ctx)
+
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultZoneTimeout
+	}
+
+	type zoneResult struct {
+		items []T
+		err   error
+	}
+	results := make([]zoneResult, len(f.Zones))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, zone := range f.Zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			zoneCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			items, err := list(zoneCtx, zoneEndpoint(zone))
+			results[i] = zoneResult{items: items, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var merged []T
+	failed := map[string]error{}
+	for i, zone := range f.Zones {
+		if err := results[i].err; err != nil {
+			logger.Error(err, "Cannot fetch zone from Exoscale", "zone", zone)
+			incZoneMetric(f.Metrics, "providerZoneFailed", zone)
+			failed[zone] = err
+			continue
+		}
+		incZoneMetric(f.Metrics, "providerZoneSucceeded", zone)
+		merged = append(merged, results[i].items...)
+	}
+
+	if len(failed) == 0 {
+		return merged, nil
+	}
+	if len(failed) == len(f.Zones) {
+		return nil, &ZoneError{Failed: failed}
+	}
+	return merged, &ZoneError{Failed: failed}
+}
+
+func incZoneMetric(zoneMetrics map[string]*prometheus.CounterVec, name, zone string) {
+	if m, ok := zoneMetrics[name]; ok {
+		m.WithLabelValues(zone).Inc()
+	}
+}
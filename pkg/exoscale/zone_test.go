@@ -0,0 +1,77 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchZones(t *testing.T) {
+	ctx := getTestContext(t)
+	fetcher := ZoneFetcher{Zones: []string{"ch-gva-2", "bg-sof-1"}, Workers: 2, Timeout: time.Second}
+
+	tests := map[string]struct {
+		failing         map[egoscale.Endpoint]bool
+		expectedResults []string
+		expectedFailed  []string
+	}{
+		"given all zones answer, we should get the results of all zones": {
+			expectedResults: []string{string(egoscale.CHGva2), string(egoscale.BGSof1)},
+		},
+		"given one zone fails, we should get the results of the others and the failed zone": {
+			failing:         map[egoscale.Endpoint]bool{egoscale.BGSof1: true},
+			expectedResults: []string{string(egoscale.CHGva2)},
+			expectedFailed:  []string{"bg-sof-1"},
+		},
+		"given all zones fail, we should get no results": {
+			failing:        map[egoscale.Endpoint]bool{egoscale.CHGva2: true, egoscale.BGSof1: true},
+			expectedFailed: []string{"ch-gva-2", "bg-sof-1"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			results, err := fetchZones(ctx, fetcher, func(_ context.Context, endpoint egoscale.Endpoint) ([]string, error) {
+				if tc.failing[endpoint] {
+					return nil, errors.New("unavailable")
+				}
+				return []string{string(endpoint)}, nil
+			})
+
+			assert.Equal(t, tc.expectedResults, results)
+			if len(tc.expectedFailed) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			zoneErr := &ZoneError{}
+			assert.ErrorAs(t, err, &zoneErr)
+			assert.Len(t, zoneErr.Failed, len(tc.expectedFailed))
+			for _, zone := range tc.expectedFailed {
+				assert.Contains(t, zoneErr.Failed, zone)
+			}
+		})
+	}
+}
+
+func TestFetchZonesTimeout(t *testing.T) {
+	ctx := getTestContext(t)
+	fetcher := ZoneFetcher{Zones: []string{"ch-gva-2", "bg-sof-1"}, Workers: 1, Timeout: 10 * time.Millisecond}
+
+	results, err := fetchZones(ctx, fetcher, func(ctx context.Context, endpoint egoscale.Endpoint) ([]string, error) {
+		if endpoint == egoscale.CHGva2 {
+			// a hanging zone must not block the others
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []string{string(endpoint)}, nil
+	})
+
+	assert.Equal(t, []string{string(egoscale.BGSof1)}, results)
+	zoneErr := &ZoneError{}
+	assert.ErrorAs(t, err, &zoneErr)
+	assert.ErrorIs(t, zoneErr.Failed["ch-gva-2"], context.DeadlineExceeded)
+}
@@ -11,6 +11,7 @@ import (
 
 	cloudscaleapis "github.com/vshn/provider-cloudscale/apis"
 	exoapis "github.com/vshn/provider-exoscale/apis"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
@@ -40,6 +41,9 @@ func NewClient(kubeconfig, url, token string) (client.Client, error) {
 	if err := orgv1.AddToScheme(scheme); err != nil {
 		return nil, fmt.Errorf("control api org scheme: %w", err)
 	}
+	if err := coordinationv1.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("coordination scheme: %w", err)
+	}
 
 	var c client.Client
 	var err error
@@ -0,0 +1,210 @@
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+// ErrLeadershipLost is returned by Run if the lease could not be renewed in time
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// Config configures the Lease used for the election.
+// A standby replica takes over at most LeaseDuration plus RetryPeriod after the leader stopped renewing.
+type Config struct {
+	// Namespace and Name of the Lease
+	Namespace, Name string
+	// Identity of this replica, usually the pod name
+	Identity string
+	// LeaseDuration is how long the lease is valid after its last renewal
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps trying to renew before it gives up leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the lease is renewed or tried to be acquired
+	RetryPeriod time.Duration
+}
+
+// Elector runs a function only while holding a coordination.k8s.io/v1 Lease
+type Elector struct {
+	client client.Client
+	cfg    Config
+	now    func() time.Time
+}
+
+// New creates an Elector, the client needs the coordination/v1 types in its scheme
+func New(c client.Client, cfg Config) (*Elector, error) {
+	if cfg.Namespace == "" || cfg.Name == "" || cfg.Identity == "" {
+		return nil, fmt.Errorf("lease namespace, name and identity are required")
+	}
+	if cfg.RenewDeadline >= cfg.LeaseDuration {
+		return nil, fmt.Errorf("renew deadline %s must be shorter than the lease duration %s", cfg.RenewDeadline, cfg.LeaseDuration)
+	}
+	if cfg.RetryPeriod <= 0 || cfg.RetryPeriod >= cfg.RenewDeadline {
+		return nil, fmt.Errorf("retry period %s must be positive and shorter than the renew deadline %s", cfg.RetryPeriod, cfg.RenewDeadline)
+	}
+	return &Elector{client: c, cfg: cfg, now: time.Now}, nil
+}
+
+// Run waits until the lease is acquired and then runs fn.
+// The context of fn is cancelled if the lease cannot be renewed within the renew deadline, Run then returns ErrLeadershipLost.
+// The lease is released when fn returns, so a standby replica can take over immediately.
+func (e *Elector) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	logger := log.Logger(ctx).WithValues("lease", e.cfg.Namespace+"/"+e.cfg.Name, "identity", e.cfg.Identity)
+
+	logger.Info("Waiting for leadership")
+	if err := e.acquire(ctx); err != nil {
+		return err
+	}
+	logger.Info("Acquired leadership")
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lost atomic.Bool
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		if e.renew(leaderCtx) {
+			logger.Info("Lost leadership")
+			lost.Store(true)
+			cancel()
+		}
+	}()
+
+	err := fn(leaderCtx)
+	cancel()
+	<-renewDone
+	if lost.Load() {
+		return ErrLeadershipLost
+	}
+
+	// use a fresh context, the parent one is usually cancelled on shutdown
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), e.cfg.RetryPeriod)
+	defer releaseCancel()
+	if releaseErr := e.release(releaseCtx); releaseErr != nil {
+		logger.Error(releaseErr, "cannot release lease")
+	} else {
+		logger.Info("Released leadership")
+	}
+	return err
+}
+
+func (e *Elector) acquire(ctx context.Context) error {
+	logger := log.Logger(ctx)
+	for {
+		ok, err := e.tryAcquireOrRenew(ctx)
+		if err != nil {
+			logger.V(1).Info("Cannot acquire lease", "reason", err.Error())
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.cfg.RetryPeriod):
+		}
+	}
+}
+
+// renew keeps renewing the lease until ctx is done.
+// It returns true if the renew deadline passed without a successful renewal.
+func (e *Elector) renew(ctx context.Context) bool {
+	logger := log.Logger(ctx)
+	lastRenew := e.now()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(e.cfg.RetryPeriod):
+		}
+
+		ok, err := e.tryAcquireOrRenew(ctx)
+		if err != nil {
+			logger.Info("Cannot renew lease", "reason", err.Error())
+		}
+		if ok {
+			lastRenew = e.now()
+			continue
+		}
+		if e.now().Sub(lastRenew) >= e.cfg.RenewDeadline {
+			return true
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it is free or expired, or renews it if this replica holds it already
+func (e *Elector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := metav1.NewMicroTime(e.now())
+	lease := &coordinationv1.Lease{}
+	err := e.client.Get(ctx, client.ObjectKey{Namespace: e.cfg.Namespace, Name: e.cfg.Name}, lease)
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: e.cfg.Namespace, Name: e.cfg.Name},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(e.cfg.Identity),
+				LeaseDurationSeconds: ptr.To(int32(e.cfg.LeaseDuration / time.Second)),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if err := e.client.Create(ctx, lease); err != nil {
+			return false, fmt.Errorf("create lease: %w", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get lease: %w", err)
+	}
+
+	holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+	if holder != e.cfg.Identity && holder != "" && !e.expired(lease) {
+		return false, nil
+	}
+
+	if holder != e.cfg.Identity {
+		lease.Spec.HolderIdentity = ptr.To(e.cfg.Identity)
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+	}
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(e.cfg.LeaseDuration / time.Second))
+	lease.Spec.RenewTime = &now
+	// the resource version makes the update fail if another replica changed the lease in the meantime
+	if err := e.client.Update(ctx, lease); err != nil {
+		return false, fmt.Errorf("update lease: %w", err)
+	}
+	return true, nil
+}
+
+func (e *Elector) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil {
+		return true
+	}
+	duration := time.Duration(ptr.Deref(lease.Spec.LeaseDurationSeconds, 0)) * time.Second
+	return e.now().After(lease.Spec.RenewTime.Add(duration))
+}
+
+// release gives up the lease if this replica still holds it
+func (e *Elector) release(ctx context.Context) error {
+	lease := &coordinationv1.Lease{}
+	if err := e.client.Get(ctx, client.ObjectKey{Namespace: e.cfg.Namespace, Name: e.cfg.Name}, lease); err != nil {
+		return fmt.Errorf("get lease: %w", err)
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != e.cfg.Identity {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	lease.Spec.AcquireTime = nil
+	return e.client.Update(ctx, lease)
+}
@@ -0,0 +1,104 @@
+package leaderelection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+func newTestElector(t *testing.T, c client.Client, identity string) *Elector {
+	e, err := New(c, Config{
+		Namespace:     "billing",
+		Name:          "collector",
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	return e
+}
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, coordinationv1.AddToScheme(scheme))
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func TestRunHandsOverOnRelease(t *testing.T) {
+	ctx := log.NewLoggingContext(context.Background(), logr.Discard())
+	c := newFakeClient(t)
+	first := newTestElector(t, c, "first")
+	second := newTestElector(t, c, "second")
+
+	release := make(chan struct{})
+	leading := make(chan string, 2)
+	go func() {
+		_ = first.Run(ctx, func(ctx context.Context) error {
+			leading <- "first"
+			<-release
+			return nil
+		})
+	}()
+	assert.Equal(t, "first", <-leading)
+
+	secondDone := make(chan error)
+	go func() {
+		secondDone <- second.Run(ctx, func(ctx context.Context) error {
+			leading <- "second"
+			return nil
+		})
+	}()
+
+	select {
+	case <-leading:
+		t.Fatal("second replica must not lead while the first one holds the lease")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case who := <-leading:
+		assert.Equal(t, "second", who)
+	case <-time.After(time.Second):
+		t.Fatal("second replica did not take over after the lease was released")
+	}
+	assert.NoError(t, <-secondDone)
+}
+
+func TestRunTakesOverExpiredLease(t *testing.T) {
+	ctx := log.NewLoggingContext(context.Background(), logr.Discard())
+	stale := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	c := newFakeClient(t, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "collector"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("dead"),
+			LeaseDurationSeconds: ptr.To(int32(1)),
+			RenewTime:            &stale,
+		},
+	})
+
+	called := false
+	err := newTestElector(t, c, "standby").Run(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+
+	lease := &coordinationv1.Lease{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "billing", Name: "collector"}, lease))
+	assert.Equal(t, int32(1), ptr.Deref(lease.Spec.LeaseTransitions, 0))
+}
@@ -0,0 +1,92 @@
+package odoo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ChunkResult is the outcome of sending a single chunk to Odoo
+type ChunkResult struct {
+	// Index is the position of the chunk in the batch, starting at 0
+	Index int
+	// Records is the number of records in the chunk
+	Records int
+	Err     error
+}
+
+// ChunkError is returned by SendData if some chunks of a batch could not be delivered
+type ChunkError struct {
+	Results []ChunkResult
+	// Failed contains the records of all failed chunks
+	Failed []OdooMeteredBillingRecord
+}
+
+func (e *ChunkError) Error() string {
+	failed := 0
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d chunks failed: %v", failed, len(e.Results), e.Unwrap())
+}
+
+func (e *ChunkError) Unwrap() error {
+	errs := make([]error, 0, len(e.Results))
+	for _, r := range e.Results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("chunk %d: %w", r.Index+1, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type chunk struct {
+	records []OdooMeteredBillingRecord
+	payload []byte
+}
+
+// chunk splits the records into payloads respecting the configured maximum number of records and bytes.
+// A single record exceeding the byte limit is sent on its own.
+func (c OdooAPIClient) chunk(data []OdooMeteredBillingRecord) ([]chunk, error) {
+	envelope := len(`{"data":[]}`)
+
+	chunks := make([]chunk, 0, 1)
+	var records []OdooMeteredBillingRecord
+	var encoded [][]byte
+	size := envelope
+
+	flush := func() {
+		if len(records) == 0 {
+			return
+		}
+		payload := bytes.NewBufferString(`{"data":[`)
+		payload.Write(bytes.Join(encoded, []byte(",")))
+		payload.WriteString(`]}`)
+		chunks = append(chunks, chunk{records: records, payload: payload.Bytes()})
+		records, encoded, size = nil, nil, envelope
+	}
+
+	for _, record := range data {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		recordSize := len(b)
+		if len(records) > 0 {
+			recordSize++ // separating comma
+		}
+		if len(records) > 0 &&
+			((c.maxRecords > 0 && len(records) >= c.maxRecords) || (c.maxBytes > 0 && size+recordSize > c.maxBytes)) {
+			flush()
+			recordSize = len(b)
+		}
+		records = append(records, record)
+		encoded = append(encoded, b)
+		size += recordSize
+	}
+	flush()
+	return chunks, nil
+}
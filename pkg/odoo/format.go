@@ -0,0 +1,86 @@
+package odoo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+var recordColumns = []string{"PRODUCT", "INSTANCE", "SALES ORDER", "UNIT", "CONSUMED UNITS", "FROM", "TO", "ITEM", "ITEM GROUP"}
+
+// WriteRecords writes the records in a human or machine readable format
+func WriteRecords(w io.Writer, format string, records []OdooMeteredBillingRecord) error {
+	switch format {
+	case FormatTable:
+		return writeTable(w, records)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(apiObject{Data: records})
+	case FormatCSV:
+		return writeCSV(w, records)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func recordRow(r OdooMeteredBillingRecord) []string {
+	return []string{
+		r.ProductID,
+		r.InstanceID,
+		r.SalesOrder,
+		r.UnitID,
+		strconv.FormatFloat(r.ConsumedUnits, 'f', -1, 64),
+		r.TimeRange.From.Format(time.RFC3339),
+		r.TimeRange.To.Format(time.RFC3339),
+		r.ItemDescription,
+		r.ItemGroupDescription,
+	}
+}
+
+func writeTable(w io.Writer, records []OdooMeteredBillingRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	writeRow := func(row []string) {
+		for i, col := range row {
+			if i > 0 {
+				_, _ = fmt.Fprint(tw, "\t")
+			}
+			_, _ = fmt.Fprint(tw, col)
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+
+	writeRow(recordColumns)
+	for _, r := range records {
+		writeRow(recordRow(r))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d records\n", len(records))
+	return err
+}
+
+func writeCSV(w io.Writer, records []OdooMeteredBillingRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(recordColumns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write(recordRow(r)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
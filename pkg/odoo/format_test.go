@@ -0,0 +1,50 @@
+package odoo
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRecords(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []OdooMeteredBillingRecord{
+		{
+			ProductID:            "appcat-cloudscale-objectstorage-storage",
+			InstanceID:           "lpg/bucket/storage",
+			ItemDescription:      "bucket",
+			ItemGroupDescription: "APPUiO Cloud - Zone: lpg / Namespace: ns",
+			SalesOrder:           "S1234",
+			UnitID:               "uom_gbday",
+			ConsumedUnits:        1.5,
+			TimeRange:            TimeRange{From: from, To: from.AddDate(0, 0, 1)},
+		},
+	}
+
+	tests := map[string]struct {
+		format   string
+		expected string
+	}{
+		"given the csv format, we should get a header and one line per record": {
+			format: FormatCSV,
+			expected: "PRODUCT,INSTANCE,SALES ORDER,UNIT,CONSUMED UNITS,FROM,TO,ITEM,ITEM GROUP\n" +
+				"appcat-cloudscale-objectstorage-storage,lpg/bucket/storage,S1234,uom_gbday,1.5,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,bucket,APPUiO Cloud - Zone: lpg / Namespace: ns\n",
+		},
+		"given the table format, we should get aligned columns and a summary": {
+			format: FormatTable,
+			expected: "PRODUCT                                  INSTANCE            SALES ORDER  UNIT       CONSUMED UNITS  FROM                  TO                    ITEM    ITEM GROUP\n" +
+				"appcat-cloudscale-objectstorage-storage  lpg/bucket/storage  S1234        uom_gbday  1.5             2024-01-01T00:00:00Z  2024-01-02T00:00:00Z  bucket  APPUiO Cloud - Zone: lpg / Namespace: ns\n" +
+				"\n1 records\n",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.NoError(t, WriteRecords(buf, tc.format, records))
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package odoo
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// IdempotencyKey returns a stable key identifying the usage billed by the record.
+// Two records for the same product, instance, sales order and time range always get the same key.
+func (r OdooMeteredBillingRecord) IdempotencyKey() string {
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		r.ProductID,
+		r.InstanceID,
+		r.SalesOrder,
+		r.TimeRange.From.UTC().Format(time.RFC3339),
+		r.TimeRange.To.UTC().Format(time.RFC3339),
+	}, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+type ledgerEntry struct {
+	Key string    `json:"key"`
+	To  time.Time `json:"to"`
+}
+
+// Ledger remembers which records have already been delivered, so the same usage is never billed twice.
+// It is stored as JSON lines file, entries whose time range ended before the retention are dropped on load.
+type Ledger struct {
+	path      string
+	retention time.Duration
+	logger    logr.Logger
+	force     bool
+
+	mu        sync.Mutex
+	delivered map[string]time.Time
+}
+
+// NewLedger loads the ledger from the given file.
+// If force is set, already delivered records are sent again but still recorded, which is useful for corrections.
+func NewLedger(path string, retention time.Duration, force bool, logger logr.Logger) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create ledger directory: %w", err)
+	}
+
+	l := &Ledger{
+		path:      path,
+		retention: retention,
+		logger:    logger,
+		force:     force,
+		delivered: map[string]time.Time{},
+	}
+	if err := l.load(time.Now().Add(-retention)); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload replaces the delivered records with the ones in the ledger file,
+// which may have been extended by another replica sharing the file in the meantime.
+func (l *Ledger) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.delivered = map[string]time.Time{}
+	return l.load(time.Now().Add(-l.retention))
+}
+
+// Wrap returns a Sink which skips records already in the ledger and adds the records delivered to next to it.
+// The idempotency key is not sent to Odoo, so Odoo cannot detect duplicates itself:
+// if the collector dies after Odoo accepted the records but before they were added to the ledger, they are sent again by the next run.
+func (l *Ledger) Wrap(next Sink) Sink {
+	return SinkFunc(func(ctx context.Context, data []OdooMeteredBillingRecord) error {
+		records := l.Filter(data)
+		if len(records) == 0 {
+			return nil
+		}
+
+		err := next.SendData(ctx, records)
+		if err != nil {
+			chunkErr := &ChunkError{}
+			if !errors.As(err, &chunkErr) {
+				return err
+			}
+			failed := make(map[string]bool, len(chunkErr.Failed))
+			for _, r := range chunkErr.Failed {
+				failed[r.IdempotencyKey()] = true
+			}
+			delivered := make([]OdooMeteredBillingRecord, 0, len(records)-len(chunkErr.Failed))
+			for _, r := range records {
+				if !failed[r.IdempotencyKey()] {
+					delivered = append(delivered, r)
+				}
+			}
+			records = delivered
+		}
+
+		if addErr := l.Add(records); addErr != nil {
+			return errors.Join(err, addErr)
+		}
+		return err
+	})
+}
+
+// Expired returns the records which end before the retention, so the ledger cannot tell whether they were delivered already.
+// Nothing is expired if force is set.
+func (l *Ledger) Expired(data []OdooMeteredBillingRecord) []OdooMeteredBillingRecord {
+	if l.force {
+		return nil
+	}
+	notBefore := time.Now().Add(-l.retention)
+	var expired []OdooMeteredBillingRecord
+	for _, r := range data {
+		if r.TimeRange.To.Before(notBefore) {
+			expired = append(expired, r)
+		}
+	}
+	return expired
+}
+
+// Filter returns the records which have not been delivered yet, duplicates within data are removed as well
+func (l *Ledger) Filter(data []OdooMeteredBillingRecord) []OdooMeteredBillingRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := make(map[string]bool, len(data))
+	records := make([]OdooMeteredBillingRecord, 0, len(data))
+	for _, r := range data {
+		key := r.IdempotencyKey()
+		if seen[key] {
+			l.logger.Info("Skipping duplicate record in batch", "productId", r.ProductID, "instanceId", r.InstanceID, "timeRange", r.TimeRange)
+			continue
+		}
+		seen[key] = true
+		if _, ok := l.delivered[key]; ok && !l.force {
+			l.logger.Info("Skipping record which has already been delivered", "productId", r.ProductID, "instanceId", r.InstanceID, "timeRange", r.TimeRange)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// Add records the given records as delivered
+func (l *Ledger) Add(data []OdooMeteredBillingRecord) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open ledger: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range data {
+		entry := ledgerEntry{Key: r.IdempotencyKey(), To: r.TimeRange.To.UTC()}
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("cannot write ledger: %w", err)
+		}
+		l.delivered[entry.Key] = entry.To
+	}
+	return f.Sync()
+}
+
+// load reads the ledger file and rewrites it without the entries ending before the given time
+func (l *Ledger) load(notBefore time.Time) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open ledger: %w", err)
+	}
+	defer f.Close()
+
+	expired := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := ledgerEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a crash while appending can leave a truncated last line behind
+			l.logger.Info("Ignoring invalid ledger entry", "reason", err.Error())
+			continue
+		}
+		if entry.To.Before(notBefore) {
+			expired++
+			continue
+		}
+		l.delivered[entry.Key] = entry.To
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read ledger: %w", err)
+	}
+
+	if expired == 0 {
+		return nil
+	}
+	l.logger.V(1).Info("Compacting ledger", "expiredEntries", expired, "entries", len(l.delivered))
+	return l.compact()
+}
+
+func (l *Ledger) compact() error {
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot compact ledger: %w", err)
+	}
+
+	enc := json.NewEncoder(f)
+	for key, to := range l.delivered {
+		if err := enc.Encode(ledgerEntry{Key: key, To: to}); err != nil {
+			f.Close()
+			return fmt.Errorf("cannot compact ledger: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot compact ledger: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
@@ -0,0 +1,133 @@
+package odoo
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger_Wrap(t *testing.T) {
+	from := time.Now().Truncate(time.Hour)
+	record1 := OdooMeteredBillingRecord{
+		ProductID:     "appcat-exoscale-v2-pg-hobbyist-2",
+		InstanceID:    "ch-gva-2/postgres-abc",
+		SalesOrder:    "1234",
+		ConsumedUnits: 1,
+		TimeRange:     TimeRange{From: from, To: from.Add(time.Hour)},
+	}
+	record2 := record1
+	record2.TimeRange = TimeRange{From: from.Add(time.Hour), To: from.Add(2 * time.Hour)}
+
+	tests := map[string]struct {
+		delivered    []OdooMeteredBillingRecord
+		force        bool
+		data         []OdooMeteredBillingRecord
+		expectedSent []OdooMeteredBillingRecord
+	}{
+		"given an empty ledger, we should send all records once": {
+			data:         []OdooMeteredBillingRecord{record1, record2, record1},
+			expectedSent: []OdooMeteredBillingRecord{record1, record2},
+		},
+		"given a delivered record, we should skip it": {
+			delivered:    []OdooMeteredBillingRecord{record1},
+			data:         []OdooMeteredBillingRecord{record1, record2},
+			expectedSent: []OdooMeteredBillingRecord{record2},
+		},
+		"given a delivered record and force, we should send it again": {
+			delivered:    []OdooMeteredBillingRecord{record1},
+			force:        true,
+			data:         []OdooMeteredBillingRecord{record1, record2},
+			expectedSent: []OdooMeteredBillingRecord{record1, record2},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger.jsonl")
+			ledger, err := NewLedger(path, time.Hour, false, logr.Discard())
+			assert.NoError(t, err)
+			assert.NoError(t, ledger.Add(tc.delivered))
+
+			// reload the ledger from disk to make sure it survives restarts
+			ledger, err = NewLedger(path, time.Hour, tc.force, logr.Discard())
+			assert.NoError(t, err)
+
+			sent := []OdooMeteredBillingRecord{}
+			sink := ledger.Wrap(SinkFunc(func(_ context.Context, data []OdooMeteredBillingRecord) error {
+				sent = append(sent, data...)
+				return nil
+			}))
+			assert.NoError(t, sink.SendData(context.Background(), tc.data))
+			assert.Equal(t, tc.expectedSent, sent)
+
+			// everything is in the ledger now, sending again must not deliver anything
+			if !tc.force {
+				sent = []OdooMeteredBillingRecord{}
+				assert.NoError(t, sink.SendData(context.Background(), tc.data))
+				assert.Empty(t, sent)
+			}
+		})
+	}
+}
+
+func TestLedger_Reload(t *testing.T) {
+	from := time.Now().Truncate(time.Hour)
+	record := OdooMeteredBillingRecord{
+		ProductID:  "appcat-exoscale-v2-pg-hobbyist-2",
+		InstanceID: "ch-gva-2/postgres-abc",
+		SalesOrder: "1234",
+		TimeRange:  TimeRange{From: from, To: from.Add(time.Hour)},
+	}
+
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	standby, err := NewLedger(path, time.Hour, false, logr.Discard())
+	assert.NoError(t, err)
+
+	// another replica delivers the record while this one is on standby
+	leader, err := NewLedger(path, time.Hour, false, logr.Discard())
+	assert.NoError(t, err)
+	assert.NoError(t, leader.Add([]OdooMeteredBillingRecord{record}))
+
+	assert.Len(t, standby.Filter([]OdooMeteredBillingRecord{record}), 1)
+	assert.NoError(t, standby.Reload())
+	assert.Empty(t, standby.Filter([]OdooMeteredBillingRecord{record}))
+}
+
+func TestLedger_Expired(t *testing.T) {
+	from := time.Now().Add(-48 * time.Hour).Truncate(time.Hour)
+	old := OdooMeteredBillingRecord{InstanceID: "old", TimeRange: TimeRange{From: from, To: from.Add(time.Hour)}}
+	recent := OdooMeteredBillingRecord{InstanceID: "recent", TimeRange: TimeRange{From: time.Now().Truncate(time.Hour), To: time.Now().Truncate(time.Hour).Add(time.Hour)}}
+
+	ledger, err := NewLedger(filepath.Join(t.TempDir(), "ledger.jsonl"), 24*time.Hour, false, logr.Discard())
+	assert.NoError(t, err)
+	assert.Equal(t, []OdooMeteredBillingRecord{old}, ledger.Expired([]OdooMeteredBillingRecord{old, recent}))
+
+	forced, err := NewLedger(filepath.Join(t.TempDir(), "ledger.jsonl"), 24*time.Hour, true, logr.Discard())
+	assert.NoError(t, err)
+	assert.Empty(t, forced.Expired([]OdooMeteredBillingRecord{old, recent}))
+}
+
+func TestOdooMeteredBillingRecord_IdempotencyKey(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := OdooMeteredBillingRecord{
+		ProductID:  "appcat-cloudscale-objectstorage-storage",
+		InstanceID: "lpg/bucket",
+		SalesOrder: "S1234",
+		TimeRange:  TimeRange{From: from, To: from.AddDate(0, 0, 1)},
+	}
+
+	location, _ := time.LoadLocation("Europe/Zurich")
+	sameInOtherZone := record
+	sameInOtherZone.TimeRange = TimeRange{From: from.In(location), To: from.AddDate(0, 0, 1).In(location)}
+	sameInOtherZone.ConsumedUnits = 42
+
+	otherDay := record
+	otherDay.TimeRange = TimeRange{From: from.AddDate(0, 0, 1), To: from.AddDate(0, 0, 2)}
+
+	assert.Equal(t, record.IdempotencyKey(), sameInOtherZone.IdempotencyKey())
+	assert.NotEqual(t, record.IdempotencyKey(), otherDay.IdempotencyKey())
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -18,15 +19,39 @@ import (
 const (
 	GB           = "GB"
 	GBDay        = "GBDay"
+	GBHour       = "GBHour"
 	KReq         = "KReq"
 	InstanceHour = "InstanceHour"
 )
 
+const (
+	// circuitBreakerThreshold is the number of consecutive failed requests after which Odoo is considered unavailable
+	circuitBreakerThreshold = 10
+	// circuitBreakerCooldown is how long requests to Odoo are suspended once the circuit breaker opened
+	circuitBreakerCooldown = 5 * time.Minute
+)
+
 type OdooAPIClient struct {
 	odooURL     string
 	logger      logr.Logger
 	oauthClient *http.Client
 	odooMetrics map[string]prometheus.Counter
+	retry       retryPolicy
+	breaker     *circuitBreaker
+	maxRecords  int
+	maxBytes    int
+}
+
+// Option configures an OdooAPIClient
+type Option func(*OdooAPIClient)
+
+// WithChunkLimits limits the number of records and the payload size in bytes of a single request to Odoo.
+// Larger batches are split into several requests. A limit of 0 disables it.
+func WithChunkLimits(maxRecords, maxBytes int) Option {
+	return func(c *OdooAPIClient) {
+		c.maxRecords = maxRecords
+		c.maxBytes = maxBytes
+	}
 }
 
 type apiObject struct {
@@ -53,52 +78,169 @@ func (t TimeRange) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + t.From.Format(time.RFC3339) + "/" + t.To.Format(time.RFC3339) + `"`), nil
 }
 
-func (t *TimeRange) UnmarshalJSON([]byte) error {
-	return errors.New("Not implemented")
+func (t *TimeRange) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	from, to, found := strings.Cut(s, "/")
+	if !found {
+		return fmt.Errorf("invalid time range %q", s)
+	}
+	var err error
+	if t.From, err = time.Parse(time.RFC3339, from); err != nil {
+		return fmt.Errorf("invalid time range start: %w", err)
+	}
+	if t.To, err = time.Parse(time.RFC3339, to); err != nil {
+		return fmt.Errorf("invalid time range end: %w", err)
+	}
+	return nil
 }
 
-func NewOdooAPIClient(ctx context.Context, odooURL string, oauthTokenURL string, oauthClientId string, oauthClientSecret string, logger logr.Logger, odooMetrics map[string]prometheus.Counter) *OdooAPIClient {
+func NewOdooAPIClient(ctx context.Context, odooURL string, oauthTokenURL string, oauthClientId string, oauthClientSecret string, logger logr.Logger, odooMetrics map[string]prometheus.Counter, opts ...Option) *OdooAPIClient {
 	oauthConfig := clientcredentials.Config{
 		ClientID:     oauthClientId,
 		ClientSecret: oauthClientSecret,
 		TokenURL:     oauthTokenURL,
 	}
 	oauthClient := oauthConfig.Client(ctx)
-	return &OdooAPIClient{
+	c := &OdooAPIClient{
 		odooURL:     odooURL,
 		logger:      logger,
 		oauthClient: oauthClient,
 		odooMetrics: odooMetrics,
+		retry:       defaultRetryPolicy,
+		breaker:     newCircuitBreaker(circuitBreakerThreshold, circuitBreakerCooldown),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c OdooAPIClient) SendData(data []OdooMeteredBillingRecord) error {
-	apiObject := apiObject{
-		Data: data,
-	}
-	str, err := json.Marshal(apiObject)
+// SendData sends the records to the Odoo API, split into chunks according to the configured limits.
+// Every chunk succeeds or fails on its own, if some chunks failed a *ChunkError with the failed records is returned.
+func (c OdooAPIClient) SendData(ctx context.Context, data []OdooMeteredBillingRecord) error {
+	chunks, err := c.chunk(data)
 	if err != nil {
 		return err
 	}
-	resp, err := c.oauthClient.Post(c.odooURL, "application/json", bytes.NewBuffer(str))
+
+	chunkErr := &ChunkError{}
+	for i, chunk := range chunks {
+		err := c.sendChunk(ctx, chunk.payload, len(chunk.records))
+		c.logger.V(1).Info("Sent chunk to Odoo API", "chunk", i+1, "chunks", len(chunks), "numberOfRecords", len(chunk.records), "bytes", len(chunk.payload), "success", err == nil)
+		chunkErr.Results = append(chunkErr.Results, ChunkResult{Index: i, Records: len(chunk.records), Err: err})
+		if err != nil {
+			chunkErr.Failed = append(chunkErr.Failed, chunk.records...)
+		}
+	}
+
+	if len(chunkErr.Failed) == 0 {
+		return nil
+	}
+	if len(chunks) == 1 {
+		return chunkErr.Results[0].Err
+	}
+	return chunkErr
+}
+
+// sendChunk sends a single payload.
+// Transport errors, 429 and 5xx responses are retried with exponential backoff, honouring a Retry-After header.
+func (c OdooAPIClient) sendChunk(ctx context.Context, payload []byte, numberOfRecords int) error {
+	for attempt := 1; ; attempt++ {
+		if !c.breaker.allow(time.Now()) {
+			c.odooMetrics["odooCircuitOpen"].Inc()
+			return ErrCircuitOpen
+		}
+
+		retryAfter, retryable, err := c.post(ctx, payload, numberOfRecords)
+		if err == nil {
+			c.breaker.success()
+			return nil
+		}
+
+		// A rejection of the records means Odoo is available, only transient failures count toward the circuit breaker
+		open := false
+		if IsPermanent(err) {
+			c.breaker.success()
+		} else {
+			open = c.breaker.failure(time.Now())
+		}
+		if !retryable || open || attempt >= c.retry.maxAttempts {
+			c.odooMetrics["odooGaveUp"].Inc()
+			return err
+		}
+
+		delay := c.retry.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		c.logger.Info("Retrying to send records to Odoo API", "attempt", attempt, "delay", delay, "reason", err.Error())
+		c.odooMetrics["odooRetried"].Inc()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// post sends the payload once. It returns the delay requested by the server and whether the request may be retried.
+func (c OdooAPIClient) post(ctx context.Context, payload []byte, numberOfRecords int) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.odooURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.oauthClient.Do(req)
 	if err != nil {
 		c.odooMetrics["odooFailed"].Inc()
-		return err
+		return 0, ctx.Err() == nil, err
 	}
 
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	c.logger.Info("Records sent to Odoo API", "status", resp.Status, "body", string(body), "numberOfRecords", len(data))
+	c.logger.Info("Records sent to Odoo API", "status", resp.Status, "body", string(body), "numberOfRecords", numberOfRecords)
 
 	if resp.StatusCode != 200 {
 		c.odooMetrics["odooFailed"].Inc()
-		return errors.New(fmt.Sprintf("API error when sending records to Odoo:\n%s", body))
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return retryAfter, isRetryableStatus(resp.StatusCode), &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	} else {
 		c.odooMetrics["odooSucceeded"].Inc()
 	}
 
-	return nil
+	return 0, false, nil
+}
+
+// APIError is returned if Odoo answered a request with an error status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error when sending records to Odoo (status %d):\n%s", e.StatusCode, e.Body)
+}
+
+// Permanent reports whether Odoo rejected the request itself, so sending it again cannot succeed.
+// Authentication errors and timeouts are not caused by the records and are therefore not permanent.
+func (e *APIError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout:
+		return false
+	}
+	return e.StatusCode >= http.StatusBadRequest && e.StatusCode < http.StatusInternalServerError && !isRetryableStatus(e.StatusCode)
+}
+
+// IsPermanent reports whether err is caused by Odoo rejecting the records, as opposed to Odoo being unavailable
+func IsPermanent(err error) bool {
+	apiErr := &APIError{}
+	return errors.As(err, &apiErr) && apiErr.Permanent()
 }
 
 func LoadUOM(uom string) (m map[string]string, err error) {
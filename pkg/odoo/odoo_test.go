@@ -0,0 +1,210 @@
+package odoo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(url string) *OdooAPIClient {
+	metrics := map[string]prometheus.Counter{}
+	for _, name := range []string{"odooFailed", "odooSucceeded", "odooRetried", "odooGaveUp", "odooCircuitOpen"} {
+		metrics[name] = prometheus.NewCounter(prometheus.CounterOpts{Name: name})
+	}
+	return &OdooAPIClient{
+		odooURL:     url,
+		logger:      logr.Discard(),
+		oauthClient: http.DefaultClient,
+		odooMetrics: metrics,
+		retry:       retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond},
+		breaker:     newCircuitBreaker(circuitBreakerThreshold, circuitBreakerCooldown),
+	}
+}
+
+func TestOdooAPIClient_SendData(t *testing.T) {
+	tests := map[string]struct {
+		statusCodes      []int
+		expectedErr      bool
+		expectedRequests int
+	}{
+		"given a successful response, we should send once": {
+			statusCodes:      []int{200},
+			expectedRequests: 1,
+		},
+		"given temporary server errors, we should retry until it succeeds": {
+			statusCodes:      []int{503, 429, 200},
+			expectedRequests: 3,
+		},
+		"given persistent server errors, we should give up after the max attempts": {
+			statusCodes:      []int{500, 500, 500, 500},
+			expectedErr:      true,
+			expectedRequests: 3,
+		},
+		"given a client error, we should not retry": {
+			statusCodes:      []int{400, 200},
+			expectedErr:      true,
+			expectedRequests: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCodes[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			err := newTestClient(server.URL).SendData(context.Background(), []OdooMeteredBillingRecord{{ProductID: "test"}})
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedRequests, requests)
+		})
+	}
+}
+
+func TestOdooAPIClient_CircuitBreaker(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	client.breaker = newCircuitBreaker(2, time.Hour)
+
+	err := client.SendData(context.Background(), []OdooMeteredBillingRecord{{ProductID: "test"}})
+	assert.Error(t, err)
+	assert.Equal(t, 2, requests)
+
+	err = client.SendData(context.Background(), []OdooMeteredBillingRecord{{ProductID: "test"}})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, requests)
+}
+
+func TestOdooAPIClient_CircuitBreakerIgnoresRejections(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	client.breaker = newCircuitBreaker(2, time.Hour)
+
+	for range 3 {
+		err := client.SendData(context.Background(), []OdooMeteredBillingRecord{{ProductID: "test"}})
+		assert.True(t, IsPermanent(err))
+	}
+	assert.Equal(t, 3, requests, "rejected records should not open the circuit")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value         string
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		"given seconds, we should get the delay":        {value: "30", expectedDelay: 30 * time.Second, expectedOk: true},
+		"given a HTTP date, we should get the delay":    {value: "Mon, 01 Jan 2024 12:01:00 GMT", expectedDelay: time.Minute, expectedOk: true},
+		"given an empty header, we should get no delay": {value: "", expectedOk: false},
+		"given garbage, we should get no delay":         {value: "soon", expectedOk: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tc.value, now)
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedDelay, delay)
+		})
+	}
+}
+
+func TestOdooAPIClient_chunk(t *testing.T) {
+	records := []OdooMeteredBillingRecord{{ProductID: "a"}, {ProductID: "b"}, {ProductID: "c"}, {ProductID: "d"}, {ProductID: "e"}}
+	recordSize := len(`{"product_id":"a","instance_id":"","sales_order_id":"","unit_id":"","consumed_units":0,"timerange":"0001-01-01T00:00:00Z/0001-01-01T00:00:00Z"}`)
+
+	tests := map[string]struct {
+		maxRecords         int
+		maxBytes           int
+		expectedChunkSizes []int
+	}{
+		"given no limits, we should get a single chunk": {
+			expectedChunkSizes: []int{5},
+		},
+		"given a record limit, we should split by number of records": {
+			maxRecords:         2,
+			expectedChunkSizes: []int{2, 2, 1},
+		},
+		"given a byte limit, we should split by payload size": {
+			maxBytes:           len(`{"data":[]}`) + 3*recordSize + 2,
+			expectedChunkSizes: []int{3, 2},
+		},
+		"given a byte limit below a single record, we should send every record on its own": {
+			maxBytes:           10,
+			expectedChunkSizes: []int{1, 1, 1, 1, 1},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient("")
+			client.maxRecords = tc.maxRecords
+			client.maxBytes = tc.maxBytes
+
+			chunks, err := client.chunk(records)
+			assert.NoError(t, err)
+
+			sizes := make([]int, 0, len(chunks))
+			for _, c := range chunks {
+				sizes = append(sizes, len(c.records))
+				if tc.maxBytes > recordSize {
+					assert.LessOrEqual(t, len(c.payload), tc.maxBytes)
+				}
+				decoded := apiObject{}
+				assert.NoError(t, json.Unmarshal(c.payload, &decoded))
+				assert.Len(t, decoded.Data, len(c.records))
+			}
+			assert.Equal(t, tc.expectedChunkSizes, sizes)
+		})
+	}
+}
+
+func TestOdooAPIClient_SendData_PartialFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	client.maxRecords = 1
+
+	records := []OdooMeteredBillingRecord{{ProductID: "a"}, {ProductID: "b"}, {ProductID: "c"}}
+	err := client.SendData(context.Background(), records)
+
+	chunkErr := &ChunkError{}
+	assert.ErrorAs(t, err, &chunkErr)
+	assert.Equal(t, []OdooMeteredBillingRecord{{ProductID: "b"}}, chunkErr.Failed)
+	assert.Len(t, chunkErr.Results, 3)
+	assert.Equal(t, 3, requests)
+}
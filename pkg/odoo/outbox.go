@@ -0,0 +1,199 @@
+package odoo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	batchFileSuffix = ".json"
+	// DeadLetterDir is the subdirectory of the outbox where records rejected by Odoo are moved to
+	DeadLetterDir = "dead-letter"
+)
+
+// Outbox persists billing records on disk until they have been delivered.
+// Every batch is written to its own file and only removed once it has been sent successfully,
+// so records survive restarts and outages of the Odoo API.
+// Records which Odoo rejects permanently are moved to the dead letter directory, so they do not block the batches after them.
+type Outbox struct {
+	dir         string
+	logger      logr.Logger
+	odooMetrics map[string]prometheus.Counter
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewOutbox creates an Outbox storing its batches in the given directory
+func NewOutbox(dir string, logger logr.Logger, odooMetrics map[string]prometheus.Counter) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Join(dir, DeadLetterDir), 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create outbox directory: %w", err)
+	}
+	return &Outbox{
+		dir:         dir,
+		logger:      logger,
+		odooMetrics: odooMetrics,
+	}, nil
+}
+
+// Enqueue writes the records as a new batch to the outbox
+func (o *Outbox) Enqueue(data []OdooMeteredBillingRecord) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), o.seq, batchFileSuffix)
+	if err := o.write(o.dir, name, data); err != nil {
+		return err
+	}
+	o.logger.V(1).Info("Enqueued records in outbox", "batch", name, "numberOfRecords", len(data))
+	return nil
+}
+
+// Flush delivers all pending batches in the order they were enqueued.
+// A batch is removed once the sink accepted it. Records rejected permanently are moved to the dead letter directory.
+// Flushing stops at the first batch which failed otherwise, e.g. because Odoo is unavailable, so it can be retried later.
+func (o *Outbox) Flush(ctx context.Context, sink Sink) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batches, err := o.pending()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range batches {
+		data, err := o.read(name)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.SendData(ctx, data); err != nil {
+			transient, permanent := splitFailed(data, err)
+			if len(permanent) > 0 {
+				// a batch can be rejected partially several times, so every rejection gets its own file
+				deadLetter := fmt.Sprintf("%s-%020d%s", strings.TrimSuffix(name, batchFileSuffix), time.Now().UnixNano(), batchFileSuffix)
+				if dlErr := o.write(filepath.Join(o.dir, DeadLetterDir), deadLetter, permanent); dlErr != nil {
+					return errors.Join(err, dlErr)
+				}
+				if m, ok := o.odooMetrics["odooDeadLettered"]; ok {
+					m.Add(float64(len(permanent)))
+				}
+				o.logger.Error(err, "Odoo rejected records, moved them to the dead letter directory", "batch", name, "numberOfRecords", len(permanent))
+			}
+			if len(transient) > 0 {
+				// only keep the records which were not delivered, so successful chunks are not sent twice
+				if len(transient) != len(data) {
+					if writeErr := o.write(o.dir, name, transient); writeErr != nil {
+						return errors.Join(err, writeErr)
+					}
+				}
+				return fmt.Errorf("cannot deliver outbox batch %s: %w", name, err)
+			}
+		}
+
+		if err := os.Remove(filepath.Join(o.dir, name)); err != nil {
+			return fmt.Errorf("cannot remove delivered outbox batch %s: %w", name, err)
+		}
+		o.logger.V(1).Info("Delivered outbox batch", "batch", name, "numberOfRecords", len(data))
+	}
+	return nil
+}
+
+// Pending returns the number of batches waiting to be delivered
+func (o *Outbox) Pending() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batches, err := o.pending()
+	return len(batches), err
+}
+
+func (o *Outbox) pending() ([]string, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list outbox: %w", err)
+	}
+
+	batches := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), batchFileSuffix) {
+			continue
+		}
+		batches = append(batches, entry.Name())
+	}
+	sort.Strings(batches)
+	return batches, nil
+}
+
+// splitFailed returns the records of a batch which failed temporarily and those which Odoo rejected permanently
+func splitFailed(data []OdooMeteredBillingRecord, err error) (transient, permanent []OdooMeteredBillingRecord) {
+	chunkErr := &ChunkError{}
+	if !errors.As(err, &chunkErr) {
+		if IsPermanent(err) {
+			return nil, data
+		}
+		return data, nil
+	}
+
+	// Failed holds the records of the failed chunks in the order of the chunks
+	offset := 0
+	for _, r := range chunkErr.Results {
+		if r.Err == nil {
+			continue
+		}
+		records := chunkErr.Failed[offset : offset+r.Records]
+		offset += r.Records
+		if IsPermanent(r.Err) {
+			permanent = append(permanent, records...)
+		} else {
+			transient = append(transient, records...)
+		}
+	}
+	return transient, permanent
+}
+
+func (o *Outbox) read(name string) ([]OdooMeteredBillingRecord, error) {
+	b, err := os.ReadFile(filepath.Join(o.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read outbox batch %s: %w", name, err)
+	}
+	batch := apiObject{}
+	if err := json.Unmarshal(b, &batch); err != nil {
+		return nil, fmt.Errorf("cannot decode outbox batch %s: %w", name, err)
+	}
+	return batch.Data, nil
+}
+
+// write stores the batch in a temporary file in dir first and renames it afterwards,
+// so a crash never leaves a partially written batch behind.
+func (o *Outbox) write(dir, name string, data []OdooMeteredBillingRecord) error {
+	b, err := json.Marshal(apiObject{Data: data})
+	if err != nil {
+		return fmt.Errorf("cannot encode outbox batch: %w", err)
+	}
+
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("cannot write outbox batch: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("cannot store outbox batch: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package odoo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox_Flush(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record1 := OdooMeteredBillingRecord{
+		ProductID:     "appcat-exoscale-v2-pg-hobbyist-2",
+		InstanceID:    "ch-gva-2/postgres-abc",
+		SalesOrder:    "1234",
+		ConsumedUnits: 1,
+		TimeRange:     TimeRange{From: from, To: from.Add(time.Hour)},
+	}
+	record2 := record1
+	record2.InstanceID = "ch-gva-2/postgres-def"
+
+	tests := map[string]struct {
+		failures           int
+		failure            error
+		expectedErr        bool
+		expectedSent       [][]OdooMeteredBillingRecord
+		expectedPending    int
+		expectedDeadLetter int
+	}{
+		"given a working API, we should deliver all batches in order and empty the outbox": {
+			failures:        0,
+			expectedSent:    [][]OdooMeteredBillingRecord{{record1}, {record2}},
+			expectedPending: 0,
+		},
+		"given a failing API, we should keep all batches in the outbox": {
+			failures:        1,
+			failure:         &APIError{StatusCode: 503},
+			expectedErr:     true,
+			expectedSent:    [][]OdooMeteredBillingRecord{},
+			expectedPending: 2,
+		},
+		"given an authentication error, we should keep all batches in the outbox": {
+			failures:        1,
+			failure:         &APIError{StatusCode: 401},
+			expectedErr:     true,
+			expectedSent:    [][]OdooMeteredBillingRecord{},
+			expectedPending: 2,
+		},
+		"given a rejected batch, we should move it to the dead letter directory and deliver the next batch": {
+			failures:           1,
+			failure:            &APIError{StatusCode: 400},
+			expectedSent:       [][]OdooMeteredBillingRecord{{record2}},
+			expectedPending:    0,
+			expectedDeadLetter: 1,
+		},
+		"given a partially rejected batch, we should keep the records which failed temporarily": {
+			failures: 1,
+			failure: &ChunkError{
+				Results: []ChunkResult{
+					{Index: 0, Records: 1, Err: &APIError{StatusCode: 422}},
+					{Index: 1, Records: 1, Err: &APIError{StatusCode: 502}},
+				},
+				Failed: []OdooMeteredBillingRecord{record1, record2},
+			},
+			expectedErr:        true,
+			expectedSent:       [][]OdooMeteredBillingRecord{},
+			expectedPending:    2,
+			expectedDeadLetter: 1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			deadLettered := prometheus.NewCounter(prometheus.CounterOpts{Name: "dead_lettered"})
+			outbox, err := NewOutbox(dir, logr.Discard(), map[string]prometheus.Counter{"odooDeadLettered": deadLettered})
+			assert.NoError(t, err)
+			assert.NoError(t, outbox.Enqueue([]OdooMeteredBillingRecord{record1}))
+			assert.NoError(t, outbox.Enqueue([]OdooMeteredBillingRecord{record2}))
+
+			failures := tc.failures
+			sent := [][]OdooMeteredBillingRecord{}
+			err = outbox.Flush(context.Background(), SinkFunc(func(_ context.Context, data []OdooMeteredBillingRecord) error {
+				if failures > 0 {
+					failures--
+					return tc.failure
+				}
+				sent = append(sent, data)
+				return nil
+			}))
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedSent, sent)
+
+			pending, err := outbox.Pending()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPending, pending)
+
+			deadLetter, err := os.ReadDir(filepath.Join(dir, DeadLetterDir))
+			assert.NoError(t, err)
+			assert.Len(t, deadLetter, min(tc.expectedDeadLetter, 1))
+			assert.Equal(t, float64(tc.expectedDeadLetter), testutil.ToFloat64(deadLettered))
+		})
+	}
+}
+
+func TestOutbox_FlushKeepsEveryRejection(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record1 := OdooMeteredBillingRecord{InstanceID: "ch-gva-2/postgres-abc", TimeRange: TimeRange{From: from, To: from.Add(time.Hour)}}
+	record2 := record1
+	record2.InstanceID = "ch-gva-2/postgres-def"
+
+	dir := t.TempDir()
+	outbox, err := NewOutbox(dir, logr.Discard(), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, outbox.Enqueue([]OdooMeteredBillingRecord{record1, record2}))
+
+	// the first record is rejected while the second one fails temporarily, so it stays in the same batch
+	err = outbox.Flush(context.Background(), SinkFunc(func(_ context.Context, data []OdooMeteredBillingRecord) error {
+		return &ChunkError{
+			Results: []ChunkResult{
+				{Index: 0, Records: 1, Err: &APIError{StatusCode: 422}},
+				{Index: 1, Records: 1, Err: &APIError{StatusCode: 502}},
+			},
+			Failed: data,
+		}
+	}))
+	assert.Error(t, err)
+
+	// the rest of the batch is rejected later
+	err = outbox.Flush(context.Background(), SinkFunc(func(_ context.Context, _ []OdooMeteredBillingRecord) error {
+		return &APIError{StatusCode: 400}
+	}))
+	assert.NoError(t, err)
+
+	deadLetter, err := os.ReadDir(filepath.Join(dir, DeadLetterDir))
+	assert.NoError(t, err)
+	assert.Len(t, deadLetter, 2, "a later rejection of the same batch should not overwrite the earlier one")
+}
@@ -0,0 +1,87 @@
+package odoo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Sink receives billing records.
+// OdooAPIClient is the sink used in production, the others are meant for dry-runs, archiving and local development.
+type Sink interface {
+	SendData(ctx context.Context, data []OdooMeteredBillingRecord) error
+}
+
+var _ Sink = &OdooAPIClient{}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, data []OdooMeteredBillingRecord) error
+
+func (f SinkFunc) SendData(ctx context.Context, data []OdooMeteredBillingRecord) error {
+	return f(ctx, data)
+}
+
+// WriterSink writes billing records as JSON lines to a writer
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a WriterSink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewStdoutSink creates a WriterSink writing to stdout
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// SendData writes one JSON object per record
+func (s *WriterSink) SendData(_ context.Context, data []OdooMeteredBillingRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(s.w)
+	for _, record := range data {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("cannot write billing record: %w", err)
+		}
+	}
+	return nil
+}
+
+// FileSink appends billing records as JSON lines to a file
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSink creates a FileSink appending to the file at path
+func NewFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create sink directory: %w", err)
+	}
+	return &FileSink{path: path}, nil
+}
+
+// SendData appends one JSON object per record to the file
+func (s *FileSink) SendData(ctx context.Context, data []OdooMeteredBillingRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open sink file: %w", err)
+	}
+	defer f.Close()
+
+	if err := NewWriterSink(f).SendData(ctx, data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,69 @@
+package orphans
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Resource is a resource of a cloud provider
+type Resource struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Zone string `json:"zone,omitempty"`
+}
+
+// Report lists the resources of a provider which have no matching object in the cluster, so they cost money without being invoiced
+type Report struct {
+	Provider    string    `json:"provider"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Kinds are the resource kinds which were checked
+	Kinds   []string   `json:"kinds"`
+	Orphans []Resource `json:"orphans"`
+	// Errors of the checks which could not be completed, the orphans of these checks may be incomplete
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Find returns the resources whose name is not claimed by an object in the cluster, sorted by kind, zone and name
+func Find(resources []Resource, claimed map[string]bool) []Resource {
+	orphans := make([]Resource, 0)
+	for _, r := range resources {
+		if !claimed[r.Name] {
+			orphans = append(orphans, r)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		if orphans[i].Zone != orphans[j].Zone {
+			return orphans[i].Zone < orphans[j].Zone
+		}
+		return orphans[i].Name < orphans[j].Name
+	})
+	return orphans
+}
+
+// WriteJSON writes the report as indented JSON
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Publish sets the number of orphans per kind of the provider, kinds without orphans are set to 0
+func (r Report) Publish(gauge *prometheus.GaugeVec) {
+	counts := make(map[string]int, len(r.Kinds))
+	for _, kind := range r.Kinds {
+		counts[kind] = 0
+	}
+	for _, o := range r.Orphans {
+		counts[o.Kind]++
+	}
+	for kind, n := range counts {
+		gauge.WithLabelValues(r.Provider, kind).Set(float64(n))
+	}
+}
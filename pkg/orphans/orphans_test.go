@@ -0,0 +1,40 @@
+package orphans
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFind(t *testing.T) {
+	resources := []Resource{
+		{Kind: "Bucket", Name: "claimed", Zone: "ch-gva-2"},
+		{Kind: "Bucket", Name: "orphan-b", Zone: "ch-gva-2"},
+		{Kind: "PostgreSQL", Name: "orphan-pg", Zone: "ch-dk-2"},
+		{Kind: "Bucket", Name: "orphan-a", Zone: "ch-gva-2"},
+	}
+
+	orphans := Find(resources, map[string]bool{"claimed": true})
+
+	assert.Equal(t, []Resource{
+		{Kind: "Bucket", Name: "orphan-a", Zone: "ch-gva-2"},
+		{Kind: "Bucket", Name: "orphan-b", Zone: "ch-gva-2"},
+		{Kind: "PostgreSQL", Name: "orphan-pg", Zone: "ch-dk-2"},
+	}, orphans)
+}
+
+func TestPublish(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "orphans"}, []string{"provider", "kind"})
+	gauge.WithLabelValues("exoscale", "Bucket").Set(5)
+
+	Report{
+		Provider: "exoscale",
+		Kinds:    []string{"Bucket", "PostgreSQL"},
+		Orphans:  []Resource{{Kind: "PostgreSQL", Name: "pg"}},
+	}.Publish(gauge)
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(gauge.WithLabelValues("exoscale", "Bucket")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge.WithLabelValues("exoscale", "PostgreSQL")))
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+func TestScheduleNext(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		expr     string
+		now      time.Time
+		expected time.Time
+	}{
+		"given hourly, we should get the top of the next hour": {
+			expr:     "@hourly",
+			now:      time.Date(2024, 3, 5, 10, 15, 30, 0, zurich),
+			expected: time.Date(2024, 3, 5, 11, 0, 0, 0, zurich),
+		},
+		"given a daily hour which has passed, we should get the next day": {
+			expr:     "0 6 * * *",
+			now:      time.Date(2024, 3, 5, 6, 0, 0, 0, zurich),
+			expected: time.Date(2024, 3, 6, 6, 0, 0, 0, zurich),
+		},
+		"given a step, we should get the next multiple": {
+			expr:     "*/15 * * * *",
+			now:      time.Date(2024, 3, 5, 10, 16, 0, 0, zurich),
+			expected: time.Date(2024, 3, 5, 10, 30, 0, 0, zurich),
+		},
+		"given a weekday range, we should skip the weekend": {
+			expr:     "30 8 * * 1-5",
+			now:      time.Date(2024, 3, 8, 9, 0, 0, 0, zurich),
+			expected: time.Date(2024, 3, 11, 8, 30, 0, 0, zurich),
+		},
+		"given day of month and day of week, we should match either of them": {
+			expr:     "0 0 15 * 0",
+			now:      time.Date(2024, 3, 5, 0, 0, 0, 0, zurich),
+			expected: time.Date(2024, 3, 10, 0, 0, 0, 0, zurich),
+		},
+		"given a step in day of month, we should match day of month and day of week": {
+			expr:     "0 0 */2 * 1",
+			now:      time.Date(2024, 3, 5, 0, 0, 0, 0, zurich),
+			expected: time.Date(2024, 3, 11, 0, 0, 0, 0, zurich),
+		},
+		"given a step in day of week, we should match day of month and day of week": {
+			expr:     "0 0 15 * */2",
+			now:      time.Date(2024, 3, 5, 0, 0, 0, 0, zurich),
+			expected: time.Date(2024, 6, 15, 0, 0, 0, 0, zurich),
+		},
+		"given midnight at the end of the year, we should roll over": {
+			expr:     "@midnight",
+			now:      time.Date(2024, 12, 31, 23, 59, 59, 0, zurich),
+			expected: time.Date(2025, 1, 1, 0, 0, 0, 0, zurich),
+		},
+		"given an hour skipped by daylight saving time, we should get the next existing hour": {
+			expr:     "0 * * * *",
+			now:      time.Date(2024, 3, 31, 1, 30, 0, 0, zurich),
+			expected: time.Date(2024, 3, 31, 3, 0, 0, 0, zurich),
+		},
+		"given an hour repeated by daylight saving time, we should get both of them": {
+			expr:     "0 * * * *",
+			now:      time.Date(2024, 10, 27, 2, 0, 0, 0, zurich),
+			expected: time.Date(2024, 10, 27, 2, 0, 0, 0, zurich).Add(time.Hour),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Equal(s.Next(tc.now)), "expected %s, got %s", tc.expected, s.Next(tc.now))
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "@often"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestGranularityPrevious(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 3, 5, 0, 0, 0, 0, zurich)
+	assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, zurich), Daily.Previous(now))
+	assert.Equal(t, time.Date(2024, 3, 4, 23, 0, 0, 0, zurich), Hourly.Previous(now))
+}
+
+func TestGranularityTruncateDST(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	// 02:00 - 03:00 happens twice on 2024-10-27, first in CEST, then in CET
+	summer := time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC).In(zurich)
+	winter := time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC).In(zurich)
+	require.Equal(t, 2, summer.Hour())
+	require.Equal(t, 2, winter.Hour())
+
+	assert.True(t, time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC).Equal(Hourly.Truncate(summer)))
+	assert.True(t, time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC).Equal(Hourly.Truncate(winter)))
+	assert.True(t, Hourly.Truncate(winter).Equal(Hourly.Next(Hourly.Truncate(summer))))
+	assert.True(t, Hourly.Truncate(summer).Equal(Hourly.Previous(winter)))
+}
+
+func TestGranularityTruncateHalfHourOffset(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2024, 3, 5, 10, 0, 0, 0, kolkata), Hourly.Truncate(time.Date(2024, 3, 5, 10, 45, 0, 0, kolkata)))
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	s, err := New("* * * * *", DefaultTimezone)
+	require.NoError(t, err)
+
+	start := time.Date(2024, 3, 5, 10, 0, 0, 0, s.Location())
+	clock := 0
+	s.now = func() time.Time {
+		// the clock advances a minute whenever it is read, so every activation is due immediately
+		clock++
+		return start.Add(time.Duration(clock) * time.Minute)
+	}
+	calls := 0
+
+	ctx, cancel := context.WithCancel(log.NewLoggingContext(context.Background(), logr.Discard()))
+	err = s.Run(ctx, func(_ context.Context, tick time.Time) error {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
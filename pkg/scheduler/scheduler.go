@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+// DefaultTimezone is the timezone billing windows are aligned to
+const DefaultTimezone = "Europe/Zurich"
+
+// Job is run at every activation of the schedule, tick is the activation time in the scheduler's timezone
+type Job func(ctx context.Context, tick time.Time) error
+
+// Scheduler runs a job according to a cron expression
+type Scheduler struct {
+	schedule Schedule
+	location *time.Location
+	now      func() time.Time
+}
+
+// New creates a Scheduler from a cron expression evaluated in the given timezone
+func New(expr, timezone string) (*Scheduler, error) {
+	schedule, err := Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("load location: %w", err)
+	}
+	return &Scheduler{
+		schedule: schedule,
+		location: location,
+		now:      time.Now,
+	}, nil
+}
+
+// Location returns the timezone of the scheduler
+func (s *Scheduler) Location() *time.Location {
+	return s.location
+}
+
+// Next returns the next activation after now, or the zero time if the schedule never activates
+func (s *Scheduler) Next() time.Time {
+	return s.schedule.Next(s.now().In(s.location))
+}
+
+// Run calls job at every activation until ctx is cancelled.
+// Failed jobs are logged and retried at the next activation, Run only returns once ctx is done.
+func (s *Scheduler) Run(ctx context.Context, job Job) error {
+	logger := log.Logger(ctx)
+
+	for ctx.Err() == nil {
+		next := s.Next()
+		if next.IsZero() {
+			return fmt.Errorf("schedule never activates")
+		}
+		logger.V(1).Info("Waiting for next activation", "next", next)
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("Received context cancellation, stopping scheduler")
+			return nil
+		case <-timer.C:
+		}
+
+		if err := job(ctx, next); err != nil {
+			logger.Error(err, "scheduled job failed", "tick", next)
+		}
+	}
+	logger.Info("Received context cancellation, stopping scheduler")
+	return nil
+}
@@ -0,0 +1,40 @@
+package scheduler
+
+import "time"
+
+// Granularity is the length of the billing window a collector bills at once
+type Granularity int
+
+const (
+	Hourly Granularity = iota
+	Daily
+)
+
+// Truncate returns the start of the window t is in.
+// Days start at midnight on the wall clock of the location of t. Hours are truncated in absolute time,
+// so the hour repeated when daylight saving time ends yields two distinct windows.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	if g == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	// shift zones with an offset which is not a whole hour, e.g. India, so the window starts at a full hour on their wall clock
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second % time.Hour
+	return t.Add(shift).Truncate(time.Hour).Add(-shift)
+}
+
+// Next returns the start of the window following the one starting at t
+func (g Granularity) Next(t time.Time) time.Time {
+	if g == Daily {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+// Previous returns the start of the last completed window before t, e.g. yesterday midnight for Daily
+func (g Granularity) Previous(t time.Time) time.Time {
+	if g == Daily {
+		return g.Truncate(t).AddDate(0, 0, -1)
+	}
+	return g.Truncate(t).Add(-time.Hour)
+}
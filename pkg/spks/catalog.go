@@ -0,0 +1,116 @@
+package spks
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// defaultCatalog is the catalog used if no catalog file is configured
+//
+//go:embed catalog.yaml
+var defaultCatalog []byte
+
+// Catalog lists the SPKS services to bill, it is read from YAML or JSON
+type Catalog struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a billed SPKS service.
+// Query, ProductID and InstanceID are templates with the fields of Params, Query has to return a single sample with the count of instances.
+type Entry struct {
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+	ProductID  string `json:"productId"`
+	InstanceID string `json:"instanceId"`
+	// Unit is the Odoo unit of measure id, the default unit is used if it is empty
+	Unit string `json:"unit,omitempty"`
+}
+
+// Params are the values available in the templates of an entry
+type Params struct {
+	ServiceSLA  string
+	Environment string
+}
+
+// LoadCatalog reads the catalog from the given file, or returns the default catalog if path is empty
+func LoadCatalog(path string) (Catalog, error) {
+	if path == "" {
+		return ParseCatalog(defaultCatalog)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("cannot read query catalog: %w", err)
+	}
+	return ParseCatalog(data)
+}
+
+// ParseCatalog parses and validates a catalog in YAML or JSON
+func ParseCatalog(data []byte) (Catalog, error) {
+	var c Catalog
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return Catalog{}, fmt.Errorf("cannot parse query catalog: %w", err)
+	}
+	if len(c.Entries) == 0 {
+		return Catalog{}, fmt.Errorf("query catalog has no entries")
+	}
+
+	names := map[string]bool{}
+	for i, e := range c.Entries {
+		if e.Name == "" || e.Query == "" || e.ProductID == "" || e.InstanceID == "" {
+			return Catalog{}, fmt.Errorf("entry %d of query catalog needs a name, query, productId and instanceId", i)
+		}
+		if names[e.Name] {
+			return Catalog{}, fmt.Errorf("entry %s is defined more than once in query catalog", e.Name)
+		}
+		names[e.Name] = true
+		// render once so invalid templates fail at startup instead of at the first run
+		if _, err := e.render(Params{}, ""); err != nil {
+			return Catalog{}, err
+		}
+	}
+	return c, nil
+}
+
+// Render returns the entries with their templates rendered, the default unit is set on the entries without unit
+func (c Catalog) Render(p Params, defaultUnit string) ([]Entry, error) {
+	entries := make([]Entry, 0, len(c.Entries))
+	for _, e := range c.Entries {
+		rendered, err := e.render(p, defaultUnit)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, rendered)
+	}
+	return entries, nil
+}
+
+func (e Entry) render(p Params, defaultUnit string) (Entry, error) {
+	rendered := Entry{Name: e.Name, Unit: e.Unit}
+	if rendered.Unit == "" {
+		rendered.Unit = defaultUnit
+	}
+	for _, field := range []struct {
+		text string
+		dst  *string
+	}{
+		{e.Query, &rendered.Query},
+		{e.ProductID, &rendered.ProductID},
+		{e.InstanceID, &rendered.InstanceID},
+	} {
+		tmpl, err := template.New(e.Name).Option("missingkey=error").Parse(field.text)
+		if err != nil {
+			return Entry{}, fmt.Errorf("entry %s: %w", e.Name, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, p); err != nil {
+			return Entry{}, fmt.Errorf("entry %s: %w", e.Name, err)
+		}
+		*field.dst = buf.String()
+	}
+	return rendered, nil
+}
@@ -0,0 +1,79 @@
+package spks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCatalog_Default(t *testing.T) {
+	catalog, err := LoadCatalog("")
+	require.NoError(t, err)
+
+	entries, err := catalog.Render(Params{ServiceSLA: "premium", Environment: "prod"}, "uom-instance")
+	require.NoError(t, err)
+
+	assert.Equal(t, []Entry{
+		{
+			Name:       "mariadb",
+			Query:      `count(max by(name)(max_over_time(crossplane_resource_info{kind="compositemariadbinstances", service_level="premium"}[1d:1d])))`,
+			ProductID:  "appcat-spks-mariadb-premium",
+			InstanceID: "mariadb-prod",
+			Unit:       "uom-instance",
+		},
+		{
+			Name:       "redis",
+			Query:      `count(max by(name)(max_over_time(crossplane_resource_info{kind="compositeredisinstances", service_level="premium"}[1d:1d])))`,
+			ProductID:  "appcat-spks-redis-premium",
+			InstanceID: "redis-prod",
+			Unit:       "uom-instance",
+		},
+	}, entries)
+}
+
+func TestParseCatalog(t *testing.T) {
+	tests := map[string]struct {
+		data    string
+		want    []Entry
+		wantErr bool
+	}{
+		"given a JSON catalog with unit, we should keep the unit": {
+			data: `{"entries": [{"name": "postgres", "query": "count(pg)", "productId": "appcat-spks-postgres-{{ .ServiceSLA }}", "instanceId": "postgres-{{ .Environment }}", "unit": "uom-pg"}]}`,
+			want: []Entry{{Name: "postgres", Query: "count(pg)", ProductID: "appcat-spks-postgres-standard", InstanceID: "postgres-nonprod", Unit: "uom-pg"}},
+		},
+		"given no entries, we should fail": {
+			data:    `entries: []`,
+			wantErr: true,
+		},
+		"given an entry without query, we should fail": {
+			data:    "entries:\n- name: minio\n  productId: appcat-spks-minio\n  instanceId: minio",
+			wantErr: true,
+		},
+		"given duplicate names, we should fail": {
+			data:    "entries:\n- {name: a, query: q, productId: p, instanceId: i}\n- {name: a, query: q, productId: p, instanceId: i}",
+			wantErr: true,
+		},
+		"given an unknown template field, we should fail": {
+			data:    "entries:\n- {name: a, query: q, productId: '{{ .Zone }}', instanceId: i}",
+			wantErr: true,
+		},
+		"given an unknown key, we should fail": {
+			data:    "entries:\n- {name: a, query: q, productId: p, instanceId: i, product: p}",
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			catalog, err := ParseCatalog([]byte(tc.data))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			entries, err := catalog.Render(Params{ServiceSLA: "standard", Environment: "nonprod"}, "uom-default")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, entries)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package unattributed
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vshn/billing-collector-cloudservices/pkg/log"
+)
+
+// Action is what happens with usage which cannot be attributed to an organization
+type Action string
+
+const (
+	// BillOrganization bills the usage to a configured organization
+	BillOrganization Action = "bill-org"
+	// Skip does not bill the usage
+	Skip Action = "skip"
+	// BillSalesOrder bills the usage to a dedicated internal sales order
+	BillSalesOrder Action = "sales-order"
+)
+
+// Policy decides what happens with usage which cannot be attributed to an organization
+type Policy struct {
+	Action Action
+	// Organization is the organization billed with BillOrganization
+	Organization string
+	// SalesOrder is the sales order billed with BillSalesOrder
+	SalesOrder string
+	// Provider is the cloud provider label of the metric, e.g. cloudscale
+	Provider string
+	// Metric counts the decisions by provider, kind and action
+	Metric *prometheus.CounterVec
+}
+
+// Validate returns an error if the policy is incomplete
+func (p Policy) Validate() error {
+	switch p.Action {
+	case Skip:
+		return nil
+	case BillOrganization:
+		if p.Organization == "" {
+			return fmt.Errorf("unattributed usage policy %s requires an organization", p.Action)
+		}
+		return nil
+	case BillSalesOrder:
+		if p.SalesOrder == "" {
+			return fmt.Errorf("unattributed usage policy %s requires a sales order", p.Action)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown unattributed usage policy %q, expected one of %s, %s or %s", p.Action, BillOrganization, Skip, BillSalesOrder)
+}
+
+// Decision is the action taken for a resource whose usage cannot be attributed
+type Decision struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Action Action `json:"action"`
+	// Organization is set if the usage is billed to an organization
+	Organization string `json:"organization,omitempty"`
+	// SalesOrder is set if the usage is billed to a sales order
+	SalesOrder string `json:"salesOrder,omitempty"`
+}
+
+// Report collects the decisions of a collector run
+type Report struct {
+	policy    Policy
+	Decisions []Decision
+}
+
+// NewReport creates the report of a collector run
+func (p Policy) NewReport() *Report {
+	return &Report{policy: p}
+}
+
+// Decide applies the policy to a resource which cannot be attributed, reason describes why.
+// The decision is counted in the metric and added to the report.
+// A zero policy skips the usage.
+func (r *Report) Decide(kind, name, reason string) Decision {
+	d := Decision{Kind: kind, Name: name, Reason: reason, Action: r.policy.Action}
+	switch d.Action {
+	case BillOrganization:
+		d.Organization = r.policy.Organization
+	case BillSalesOrder:
+		d.SalesOrder = r.policy.SalesOrder
+	default:
+		d.Action = Skip
+	}
+
+	if r.policy.Metric != nil {
+		r.policy.Metric.WithLabelValues(r.policy.Provider, kind, string(d.Action)).Inc()
+	}
+	r.Decisions = append(r.Decisions, d)
+	return d
+}
+
+// Log writes the report of the run, if there were any decisions
+func (r *Report) Log(ctx context.Context) {
+	if len(r.Decisions) == 0 {
+		return
+	}
+
+	counts := map[Action]int{}
+	for _, d := range r.Decisions {
+		counts[d.Action]++
+	}
+	sort.SliceStable(r.Decisions, func(i, j int) bool {
+		if r.Decisions[i].Kind != r.Decisions[j].Kind {
+			return r.Decisions[i].Kind < r.Decisions[j].Kind
+		}
+		return r.Decisions[i].Name < r.Decisions[j].Name
+	})
+
+	log.Logger(ctx).Info("Usage could not be attributed to an organization",
+		"provider", r.policy.Provider,
+		string(BillOrganization), counts[BillOrganization],
+		string(Skip), counts[Skip],
+		string(BillSalesOrder), counts[BillSalesOrder],
+		"decisions", r.Decisions)
+}
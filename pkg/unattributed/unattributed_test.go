@@ -0,0 +1,60 @@
+package unattributed
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := map[string]struct {
+		policy  Policy
+		wantErr bool
+	}{
+		"given skip, we should accept it": {
+			policy: Policy{Action: Skip},
+		},
+		"given bill-org with organization, we should accept it": {
+			policy: Policy{Action: BillOrganization, Organization: "vshn"},
+		},
+		"given bill-org without organization, we should fail": {
+			policy:  Policy{Action: BillOrganization},
+			wantErr: true,
+		},
+		"given sales-order without sales order, we should fail": {
+			policy:  Policy{Action: BillSalesOrder, Organization: "vshn"},
+			wantErr: true,
+		},
+		"given an unknown action, we should fail": {
+			policy:  Policy{Action: "ignore"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReport_Decide(t *testing.T) {
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unattributed"}, []string{"provider", "kind", "action"})
+
+	report := Policy{Action: BillSalesOrder, SalesOrder: "S-internal", Organization: "vshn", Provider: "cloudscale", Metric: metric}.NewReport()
+	d := report.Decide("Bucket", "b", "no namespace")
+	report.Decide("Bucket", "c", "no namespace")
+
+	assert.Equal(t, Decision{Kind: "Bucket", Name: "b", Reason: "no namespace", Action: BillSalesOrder, SalesOrder: "S-internal"}, d)
+	assert.Len(t, report.Decisions, 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metric.WithLabelValues("cloudscale", "Bucket", string(BillSalesOrder))))
+
+	d = Policy{}.NewReport().Decide("Volume", "v", "no namespace")
+	assert.Equal(t, Skip, d.Action, "a zero policy should skip")
+}